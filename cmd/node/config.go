@@ -1,6 +1,7 @@
 package main
 
 import (
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -21,14 +22,28 @@ type NameService struct {
 type State struct {
 	Beneficiary    string
 	DBPath         string
+	Storage        string
 	SelectStrategy string
 	OriginPeers    []string
 	Consensus      string
+	MiningWorkers  int
+
+	SnapshotInterval uint64
+	FullReplay       bool
+
+	MempoolMaxTxs        int
+	MempoolMaxBytes      int
+	MempoolMaxPerAccount int
+	MempoolEviction      string
+	StrictAdmission      bool
+	MempoolTTL           time.Duration
+	MempoolJournal       string
 }
 
 type WebConfig struct {
 	Addr            string
 	PrivateAddr     string
+	WSOrigins       []string
 	WriteTimeout    time.Duration
 	ReadTimeout     time.Duration
 	IdleTimeout     time.Duration
@@ -41,12 +56,31 @@ func NewConfig() Config {
 	idleTimeout, _ := strconv.Atoi(getenv.GetEnv("WEB_IDLE_TIMEOUT", "120"))
 	shutDownTimeout, _ := strconv.Atoi(getenv.GetEnv("WEB_SHUTDOWN_TIMEOUT", "20"))
 
+	miningWorkers, _ := strconv.Atoi(getenv.GetEnv("MINING_WORKERS", "0"))
+
+	snapshotInterval, _ := strconv.ParseUint(getenv.GetEnv("SNAPSHOT_INTERVAL", "1000"), 10, 64)
+	fullReplay, _ := strconv.ParseBool(getenv.GetEnv("FULL_REPLAY", "false"))
+
+	mempoolMaxTxs, _ := strconv.Atoi(getenv.GetEnv("MEMPOOL_MAX_TXS", "10000"))
+	mempoolMaxBytes, _ := strconv.Atoi(getenv.GetEnv("MEMPOOL_MAX_BYTES", "33554432"))
+	mempoolMaxPerAccount, _ := strconv.Atoi(getenv.GetEnv("MEMPOOL_MAX_PER_ACCOUNT", "64"))
+	strictAdmission, _ := strconv.ParseBool(getenv.GetEnv("MEMPOOL_STRICT_ADMISSION", "false"))
+	mempoolTTL, _ := time.ParseDuration(getenv.GetEnv("MEMPOOL_TTL", "72h"))
+
+	dbPath := getenv.GetEnv("DB_PATH", "data/miner1/")
+
 	originPeers := strings.Split(getenv.GetEnv("ORIGIN_PEERS", "0.0.0.0:3030"), ",")
 
+	var wsOrigins []string
+	if origins := getenv.GetEnv("WS_ALLOWED_ORIGINS", ""); origins != "" {
+		wsOrigins = strings.Split(origins, ",")
+	}
+
 	return Config{
 		Web: WebConfig{
 			Addr:            getenv.GetEnv("WEB_ADDR", "0.0.0.0:3000"),
 			PrivateAddr:     getenv.GetEnv("WEB_PRIVATE_ADDR", "0.0.0.0:3030"),
+			WSOrigins:       wsOrigins,
 			WriteTimeout:    time.Duration(writeTimeout) * time.Second,
 			ReadTimeout:     time.Duration(readTimeout) * time.Second,
 			IdleTimeout:     time.Duration(idleTimeout) * time.Second,
@@ -57,10 +91,23 @@ func NewConfig() Config {
 		},
 		State: State{
 			Beneficiary:    getenv.GetEnv("BENEFICIARY", "miner1"),
-			DBPath:         getenv.GetEnv("DB_PATH", "data/miner1/"),
+			DBPath:         dbPath,
+			Storage:        getenv.GetEnv("STORAGE", "disk"),
 			SelectStrategy: getenv.GetEnv("SELECT_STRATEGY", "Tip"),
 			OriginPeers:    originPeers,
 			Consensus:      getenv.GetEnv("CONSENSUS", "POW"),
+			MiningWorkers:  miningWorkers,
+
+			SnapshotInterval: snapshotInterval,
+			FullReplay:       fullReplay,
+
+			MempoolMaxTxs:        mempoolMaxTxs,
+			MempoolMaxBytes:      mempoolMaxBytes,
+			MempoolMaxPerAccount: mempoolMaxPerAccount,
+			MempoolEviction:      getenv.GetEnv("MEMPOOL_EVICTION", "tip"),
+			StrictAdmission:      strictAdmission,
+			MempoolTTL:           mempoolTTL,
+			MempoolJournal:       getenv.GetEnv("MEMPOOL_JOURNAL", filepath.Join(dbPath, "mempool.journal")),
 		},
 	}
 }
@@ -8,17 +8,21 @@ import (
 	"os"
 	"os/signal"
 	"runtime"
+	"strings"
 	"syscall"
 
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/joho/godotenv"
 	"github.com/labstack/echo/v4"
 	"github.com/opplieam/bund-blockchain/internal/blockchain/database"
+	"github.com/opplieam/bund-blockchain/internal/blockchain/events"
 	"github.com/opplieam/bund-blockchain/internal/blockchain/genesis"
 	"github.com/opplieam/bund-blockchain/internal/blockchain/peer"
 	"github.com/opplieam/bund-blockchain/internal/blockchain/state"
 	"github.com/opplieam/bund-blockchain/internal/blockchain/storage/disk"
+	"github.com/opplieam/bund-blockchain/internal/blockchain/storage/kv"
 	"github.com/opplieam/bund-blockchain/internal/blockchain/worker"
+	"github.com/opplieam/bund-blockchain/internal/metrics"
 	"github.com/opplieam/bund-blockchain/internal/nameservice"
 )
 
@@ -78,16 +82,17 @@ func run(log *slog.Logger) error {
 	}
 	peerSet.Add(peer.New(cfg.Web.PrivateAddr))
 
-	ev := func(v string, args ...any) {
-		s := fmt.Sprintf(v, args...)
-		log.Info(s, "trace_id", "00000000-0000-0000-0000-000000000000")
-	}
+	// Every subsystem publishes what it's doing on the event bus. Logging is
+	// one of the consumers.
+	bus := events.NewBus()
+	bus.Subscribe(events.Log(log))
 
-	// Construct the use of disk storage
-	storage, err := disk.New(cfg.State.DBPath)
+	// Construct the storage the blockchain is written to.
+	storage, err := newStorage(log, cfg.State.Storage, cfg.State.DBPath)
 	if err != nil {
 		return err
 	}
+	defer storage.Close()
 
 	// Load the genesis file for blockchain settings and origin balances.
 	genesisInfo, err := genesis.Load()
@@ -99,23 +104,40 @@ func run(log *slog.Logger) error {
 	// database and provides an API for application support.
 	stateM, err := state.New(state.Config{
 		BeneficiaryID:  database.PublicKeyToAccountID(privateKey.PublicKey),
+		SignerKey:      privateKey,
 		Host:           cfg.Web.PrivateAddr,
 		Storage:        storage,
 		Genesis:        genesisInfo,
 		SelectStrategy: cfg.State.SelectStrategy,
 		KnownPeers:     peerSet,
-		EvHandler:      ev,
+		Events:         bus,
 		Consensus:      cfg.State.Consensus,
+		MiningWorkers:  cfg.State.MiningWorkers,
+
+		SnapshotInterval: cfg.State.SnapshotInterval,
+		FullReplay:       cfg.State.FullReplay,
+
+		MempoolMaxTxs:        cfg.State.MempoolMaxTxs,
+		MempoolMaxBytes:      cfg.State.MempoolMaxBytes,
+		MempoolMaxPerAccount: cfg.State.MempoolMaxPerAccount,
+		MempoolEviction:      cfg.State.MempoolEviction,
+		StrictAdmission:      cfg.State.StrictAdmission,
+		MempoolTTL:           cfg.State.MempoolTTL,
+		MempoolJournal:       cfg.State.MempoolJournal,
 	})
 	if err != nil {
 		return err
 	}
 	defer stateM.Shutdown()
 
+	// Measurements of the node internals are collected from the events
+	// published by the state and served to Prometheus.
+	mtr := metrics.New(stateM)
+
 	// The worker package implements the different workflows such as mining,
 	// transaction peer sharing, and peer updates. The worker will register
 	// itself with the state.
-	worker.Run(stateM, ev)
+	worker.Run(stateM)
 
 	// Make a channel to listen for an interrupt or terminate signal from the OS.
 	// Use a buffered channel because the signal package requires it.
@@ -127,7 +149,7 @@ func run(log *slog.Logger) error {
 
 	// ===========================================================================================
 	e := echo.New()
-	setupRoutes(e, log, stateM, ns)
+	setupRoutes(e, log, stateM, ns, cfg.Web.WSOrigins)
 
 	publicSrv := &http.Server{
 		Addr:         cfg.Web.Addr,
@@ -143,7 +165,7 @@ func run(log *slog.Logger) error {
 
 	// ===========================================================================================
 	pe := echo.New()
-	setupPrivateRoutes(pe, log, stateM, ns)
+	setupPrivateRoutes(pe, log, stateM, ns, mtr)
 
 	privateSrv := &http.Server{
 		Addr:         cfg.Web.PrivateAddr,
@@ -180,3 +202,22 @@ func run(log *slog.Logger) error {
 
 	return nil
 }
+
+// newStorage constructs the storage implementation for the specified kind.
+func newStorage(log *slog.Logger, kind string, dbPath string) (database.Storage, error) {
+	switch strings.ToLower(kind) {
+	case "disk":
+		d, err := disk.New(dbPath)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range d.Quarantined() {
+			log.Info("startup", "status", "storage", "quarantined", name)
+		}
+		return d, nil
+	case "kv":
+		return kv.New(dbPath)
+	}
+
+	return nil, fmt.Errorf("storage %q does not exist", kind)
+}
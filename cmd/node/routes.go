@@ -7,27 +7,36 @@ import (
 	"github.com/labstack/echo/v4/middleware"
 	"github.com/opplieam/bund-blockchain/internal/blockchain/state"
 	"github.com/opplieam/bund-blockchain/internal/handler"
+	"github.com/opplieam/bund-blockchain/internal/metrics"
 	"github.com/opplieam/bund-blockchain/internal/nameservice"
 	slogecho "github.com/samber/slog-echo"
 )
 
-func setupRoutes(e *echo.Echo, log *slog.Logger, state *state.State, ns *nameservice.NameService) {
+func setupRoutes(e *echo.Echo, log *slog.Logger, state *state.State, ns *nameservice.NameService, wsOrigins []string) {
 	e.Use(slogecho.New(log))
 	e.Use(middleware.Recover())
 
 	h := handler.New(log, state, ns)
+	h.WSOrigins = wsOrigins
 
 	e.GET("/genesis/list", h.Genesis)
+	e.GET("/supply", h.Supply)
 	e.GET("/accounts/list", h.Accounts)
 	e.GET("/accounts/list/:account", h.Accounts)
+	e.GET("/accounts/:account/txs", h.AccountTransactions)
 	e.GET("/tx/uncommitted/list", h.Mempool)
 	e.GET("/tx/uncommitted/list/:account", h.Mempool)
 	e.POST("/tx/submit", h.SubmitWalletTransaction)
-	//e.POST("/tx/proof/:block")
+	e.GET("/tx/:hash", h.TransactionByHash)
+	e.GET("/tx/:hash/receipt", h.TransactionReceipt)
+	e.GET("/block/:hash", h.BlockByHash)
+	e.POST("/rpc", h.RPC)
+	e.GET("/tx/proof/:block/:hash", h.TransactionProof)
+	e.GET("/ws", h.Subscribe)
 
 }
 
-func setupPrivateRoutes(e *echo.Echo, log *slog.Logger, state *state.State, ns *nameservice.NameService) {
+func setupPrivateRoutes(e *echo.Echo, log *slog.Logger, state *state.State, ns *nameservice.NameService, mtr *metrics.Metrics) {
 	e.Use(slogecho.New(log))
 	e.Use(middleware.Recover())
 
@@ -38,4 +47,5 @@ func setupPrivateRoutes(e *echo.Echo, log *slog.Logger, state *state.State, ns *
 	e.POST("/node/tx/submit", h.SubmitNodeTransaction)
 	e.POST("/node/block/propose", h.ProposeBlock)
 	e.GET("/node/block/list/:from/:to", h.BlocksByNumber)
+	e.GET("/metrics", echo.WrapHandler(mtr))
 }
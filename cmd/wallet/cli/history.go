@@ -0,0 +1,77 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/opplieam/bund-blockchain/internal/blockchain/database"
+	"github.com/spf13/cobra"
+)
+
+var (
+	direction string
+	page      int
+	rows      int
+)
+
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "Print the mined transactions for the wallet",
+	Run:   historyRun,
+}
+
+func init() {
+	rootCmd.AddCommand(historyCmd)
+	historyCmd.Flags().StringVarP(&url, "url", "u", "http://localhost:3000", "Url of the node.")
+	historyCmd.Flags().StringVarP(&direction, "direction", "d", database.DirectionAll, "Transactions to show: all, sent or received.")
+	historyCmd.Flags().IntVarP(&page, "page", "g", 1, "Page of transactions to show.")
+	historyCmd.Flags().IntVarP(&rows, "rows", "r", 20, "Number of transactions per page.")
+}
+
+func historyRun(cmd *cobra.Command, args []string) {
+	privateKey, err := crypto.LoadECDSA(getPrivateKeyPath())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	accountID := database.PublicKeyToAccountID(privateKey.PublicKey)
+
+	resp, err := http.Get(fmt.Sprintf("%s/accounts/%s/txs?direction=%s&page=%d&rows=%d", url, accountID, direction, page, rows))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		log.Fatalf("%s: %s", resp.Status, msg)
+	}
+
+	var history struct {
+		Txs []struct {
+			BlockNumber uint64 `json:"block_number"`
+			Direction   string `json:"direction"`
+			Status      string `json:"status"`
+			Tx          struct {
+				Hash  string             `json:"hash"`
+				From  database.AccountID `json:"from"`
+				To    database.AccountID `json:"to"`
+				Nonce uint64             `json:"nonce"`
+				Value uint64             `json:"value"`
+				Tip   uint64             `json:"tip"`
+			} `json:"tx"`
+		} `json:"txs"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&history); err != nil {
+		log.Fatal(err)
+	}
+
+	for _, tx := range history.Txs {
+		fmt.Printf("blk[%d] %-8s %-7s nonce[%d] from[%s] to[%s] value[%d] tip[%d] %s\n",
+			tx.BlockNumber, tx.Direction, tx.Status, tx.Tx.Nonce, tx.Tx.From, tx.Tx.To, tx.Tx.Value, tx.Tx.Tip, tx.Tx.Hash)
+	}
+}
@@ -0,0 +1,153 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/opplieam/bund-blockchain/internal/blockchain/database"
+	"github.com/opplieam/bund-blockchain/internal/blockchain/genesis"
+	"github.com/opplieam/bund-blockchain/internal/blockchain/merkle"
+	"github.com/spf13/cobra"
+)
+
+var (
+	proofBlock     uint64
+	proofBlockHash string
+	proofGenesis   string
+)
+
+var verifyProofCmd = &cobra.Command{
+	Use:   "verify-proof <tx hash>",
+	Short: "Verify a transaction is included in a block",
+	Args:  cobra.ExactArgs(1),
+	Run:   verifyProofRun,
+}
+
+func init() {
+	rootCmd.AddCommand(verifyProofCmd)
+	verifyProofCmd.Flags().StringVarP(&url, "url", "u", "http://localhost:3000", "Url of the node.")
+	verifyProofCmd.Flags().Uint64VarP(&proofBlock, "block", "b", 0, "Number of the block holding the transaction.")
+	verifyProofCmd.Flags().StringVarP(&proofBlockHash, "block-hash", "k", "", "Hash of the block obtained from a trusted source.")
+	verifyProofCmd.Flags().StringVarP(&proofGenesis, "genesis", "g", "conf/genesis.json", "Path to the genesis file of the chain.")
+	verifyProofCmd.MarkFlagRequired("block")
+}
+
+func verifyProofRun(cmd *cobra.Command, args []string) {
+	txHash := args[0]
+
+	resp, err := http.Get(fmt.Sprintf("%s/tx/proof/%d/%s", url, proofBlock, txHash))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		log.Fatalf("%s: %s", resp.Status, msg)
+	}
+
+	var tp struct {
+		Header database.BlockHeader `json:"header"`
+		Tx     database.BlockTx     `json:"tx"`
+		Proof  []string             `json:"proof"`
+		Order  []int64              `json:"order"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tp); err != nil {
+		log.Fatal(err)
+	}
+
+	// Nothing the node returned is trusted. The block hash is calculated
+	// from the header and checked against the hash of the block obtained
+	// from a trusted source when provided. The header must also carry the
+	// consensus proof of the chain: a POA block must be signed by the
+	// validator whose turn it was, a POW block must solve the puzzle.
+	block := database.Block{Header: tp.Header}
+	blockHash := block.Hash()
+
+	if tp.Header.Number != proofBlock {
+		log.Fatalf("header is for block %d, exp %d", tp.Header.Number, proofBlock)
+	}
+	if proofBlockHash != "" && proofBlockHash != blockHash {
+		log.Fatalf("block hash doesn't match, got %s, exp %s", blockHash, proofBlockHash)
+	}
+
+	gen, err := genesis.LoadFile(proofGenesis)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := verifyConsensus(block, gen); err != nil {
+		log.Fatal(err)
+	}
+
+	// The transaction must be the one being asked about.
+	if tp.Tx.TxHash() != txHash {
+		log.Fatalf("transaction hash doesn't match, got %s, exp %s", tp.Tx.TxHash(), txHash)
+	}
+
+	// Calculate the merkle root from the transaction and the proof and
+	// compare it to the root in the header.
+	root, err := hexutil.Decode(tp.Header.TransRoot)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	leaf, err := tp.Tx.Hash()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	proof := make([][]byte, len(tp.Proof))
+	for i, hash := range tp.Proof {
+		if proof[i], err = hexutil.Decode(hash); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if err := merkle.VerifyProof(root, leaf, proof, tp.Order); err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("transaction %s is included in block %d %s\n", txHash, tp.Header.Number, blockHash)
+
+	// A node can serve a header for a block that isn't in the chain. Only a
+	// block hash from a trusted source rules that out.
+	if proofBlockHash == "" {
+		fmt.Println("warning: the block hash was not pinned with --block-hash, the header came from the node and the proof is not trustless")
+	}
+}
+
+// verifyConsensus checks the header carries the proof the consensus of the
+// chain requires.
+func verifyConsensus(block database.Block, gen genesis.Genesis) error {
+	if len(gen.Validators) == 0 {
+		if block.Header.Difficulty == 0 {
+			return errors.New("block has no proof of work")
+		}
+		return block.VerifyPOW()
+	}
+
+	if block.Header.Difficulty != 0 {
+		return fmt.Errorf("block difficulty is %d, exp 0 under POA", block.Header.Difficulty)
+	}
+
+	signer, err := block.Signer()
+	if err != nil {
+		return err
+	}
+
+	validator, err := database.ToAccountID(gen.Validators[block.Header.Number%uint64(len(gen.Validators))])
+	if err != nil {
+		return err
+	}
+
+	if signer != validator {
+		return fmt.Errorf("block signed by %s, exp validator %s", signer, validator)
+	}
+
+	return nil
+}
@@ -0,0 +1,50 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/opplieam/bund-blockchain/internal/blockchain/database"
+	"github.com/spf13/cobra"
+)
+
+var receiptCmd = &cobra.Command{
+	Use:   "receipt <tx hash>",
+	Short: "Print the receipt for a mined transaction",
+	Args:  cobra.ExactArgs(1),
+	Run:   receiptRun,
+}
+
+func init() {
+	rootCmd.AddCommand(receiptCmd)
+	receiptCmd.Flags().StringVarP(&url, "url", "u", "http://localhost:3000", "Url of the node.")
+}
+
+func receiptRun(cmd *cobra.Command, args []string) {
+	resp, err := http.Get(fmt.Sprintf("%s/tx/%s/receipt", url, args[0]))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		log.Fatalf("%s: %s", resp.Status, msg)
+	}
+
+	var receipt database.Receipt
+	if err := json.NewDecoder(resp.Body).Decode(&receipt); err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Println("Block:      ", receipt.BlockNumber)
+	fmt.Println("Index:      ", receipt.Index)
+	fmt.Println("Status:     ", receipt.Status)
+	fmt.Println("Gas Charged:", receipt.GasCharged)
+	if receipt.Error != "" {
+		fmt.Println("Error:      ", receipt.Error)
+	}
+}
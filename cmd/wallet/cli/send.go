@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/opplieam/bund-blockchain/internal/blockchain/database"
@@ -21,6 +22,9 @@ var (
 	value uint64
 	tip   uint64
 	data  []byte
+
+	expiryBlock uint64
+	expiresIn   time.Duration
 )
 
 var sendCmd = &cobra.Command{
@@ -38,6 +42,8 @@ func init() {
 	sendCmd.Flags().Uint64VarP(&value, "value", "v", 0, "Value to send.")
 	sendCmd.Flags().Uint64VarP(&tip, "tip", "c", 0, "Tip to send.")
 	sendCmd.Flags().BytesHexVarP(&data, "data", "d", nil, "Data to send.")
+	sendCmd.Flags().Uint64Var(&expiryBlock, "expiry-block", 0, "Last block number the transaction can be mined in.")
+	sendCmd.Flags().DurationVar(&expiresIn, "expires-in", 0, "How long the transaction can wait to be mined, like 1h.")
 }
 
 func sendRun(cmd *cobra.Command, args []string) {
@@ -66,6 +72,11 @@ func sendWithDetails(privateKey *ecdsa.PrivateKey) {
 		log.Fatal(err)
 	}
 
+	tx.ExpiryBlock = expiryBlock
+	if expiresIn > 0 {
+		tx.ExpiryTime = uint64(time.Now().Add(expiresIn).UTC().UnixMilli())
+	}
+
 	signedTx, err := tx.Sign(privateKey)
 	if err != nil {
 		log.Fatal(err)
@@ -81,4 +92,21 @@ func sendWithDetails(privateKey *ecdsa.PrivateKey) {
 		log.Fatal(err)
 	}
 	defer resp.Body.Close()
+
+	// Print the hash so the receipt can be looked up once the
+	// transaction is mined, or the reason the node rejected it.
+	var response struct {
+		Hash  string `json:"hash"`
+		Code  string `json:"code"`
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return
+	}
+	if response.Code != "" {
+		log.Fatalf("%s: %s", response.Code, response.Error)
+	}
+	if response.Hash != "" {
+		fmt.Println(response.Hash)
+	}
 }
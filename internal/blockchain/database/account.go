@@ -7,6 +7,9 @@ import (
 	"github.com/ethereum/go-ethereum/crypto"
 )
 
+// ErrAccountNotFound is returned when an account has never held a balance.
+var ErrAccountNotFound = errors.New("account does not exist")
+
 // Account represents information stored in the database for an individual account.
 type Account struct {
 	AccountID AccountID
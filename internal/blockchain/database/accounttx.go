@@ -0,0 +1,202 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// CORE NOTE: The transactions an account sent or received are kept as part
+// of the index, grouped per account into buckets of block numbers just like
+// the account history.
+
+// accountTxPrefix is the prefix for the keys used to store the transactions
+// of an account.
+const accountTxPrefix = "accttx/"
+
+// Set of directions for filtering the transactions of an account.
+const (
+	DirectionAll      = "all"
+	DirectionSent     = "sent"
+	DirectionReceived = "received"
+)
+
+// AccountTx represents a mined transaction that involved an account.
+type AccountTx struct {
+	Hash        string `json:"hash"`         // Hash of the transaction.
+	BlockNumber uint64 `json:"block_number"` // Number of the block holding the transaction.
+	Index       int    `json:"index"`        // Position of the transaction in the block.
+	Sent        bool   `json:"sent"`         // The account sent the transaction.
+	Received    bool   `json:"received"`     // The account received the transaction.
+}
+
+// AccountTxs returns the mined transactions for the account that match the
+// direction, newest first. The offset and limit select the page to return.
+func (db *Database) AccountTxs(accountID AccountID, direction string, offset int, limit int) ([]AccountTx, error) {
+	switch direction {
+	case DirectionAll, DirectionSent, DirectionReceived:
+	default:
+		return nil, fmt.Errorf("invalid direction %q", direction)
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	buckets, err := db.buckets(nil, accountTxBucketsKey(accountID))
+	if err != nil {
+		return nil, err
+	}
+
+	var page []AccountTx
+	for i := len(buckets) - 1; i >= 0 && len(page) < limit; i-- {
+		entries, err := db.accountTxEntries(nil, accountID, buckets[i])
+		if err != nil {
+			return nil, err
+		}
+
+		for j := len(entries) - 1; j >= 0 && len(page) < limit; j-- {
+			entry := entries[j]
+			if (direction == DirectionSent && !entry.Sent) || (direction == DirectionReceived && !entry.Received) {
+				continue
+			}
+
+			if offset > 0 {
+				offset--
+				continue
+			}
+			page = append(page, entry)
+		}
+	}
+
+	return page, nil
+}
+
+// =============================================================================
+
+// indexAccountTxs records the transactions in the block for the accounts
+// that sent and received them into the batch. The caller must hold the write
+// lock.
+func (db *Database) indexAccountTxs(batch MetaBatch, block Block) error {
+	num := block.Header.Number
+	bucket := num / bucketSize
+
+	// Collect the transactions in the block for each account.
+	adds := make(map[AccountID][]AccountTx)
+	for i, tx := range block.MerkleTree.Values() {
+		entry := AccountTx{
+			Hash:        tx.TxHash(),
+			BlockNumber: num,
+			Index:       i,
+			Sent:        true,
+		}
+
+		// Sending to yourself is rejected by validation, but don't record
+		// the transaction twice if it happens.
+		if tx.ToID == tx.FromID {
+			entry.Received = true
+			adds[tx.FromID] = append(adds[tx.FromID], entry)
+			continue
+		}
+		adds[tx.FromID] = append(adds[tx.FromID], entry)
+
+		entry.Sent, entry.Received = false, true
+		adds[tx.ToID] = append(adds[tx.ToID], entry)
+	}
+
+	for accountID, blockEntries := range adds {
+		entries, err := db.accountTxEntries(batch, accountID, bucket)
+		if err != nil {
+			return err
+		}
+
+		// The block could be indexed again after a crash, so anything already
+		// recorded for the block is replaced.
+		var before, after []AccountTx
+		for _, entry := range entries {
+			switch {
+			case entry.BlockNumber < num:
+				before = append(before, entry)
+			case entry.BlockNumber > num:
+				after = append(after, entry)
+			}
+		}
+		entries = append(append(before, blockEntries...), after...)
+
+		if err := db.putIndex(batch, accountTxKey(accountID, bucket), entries); err != nil {
+			return err
+		}
+
+		if err := db.updateBuckets(batch, accountTxBucketsKey(accountID), bucket, true); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// unindexAccountTxs removes the transactions in the block from the accounts
+// that sent and received them in the batch. The caller must hold the write
+// lock.
+func (db *Database) unindexAccountTxs(batch MetaBatch, block Block) error {
+	num := block.Header.Number
+	bucket := num / bucketSize
+
+	accountIDs := make(map[AccountID]struct{})
+	for _, tx := range block.MerkleTree.Values() {
+		accountIDs[tx.FromID] = struct{}{}
+		accountIDs[tx.ToID] = struct{}{}
+	}
+
+	for accountID := range accountIDs {
+		entries, err := db.accountTxEntries(batch, accountID, bucket)
+		if err != nil {
+			return err
+		}
+
+		kept := entries[:0]
+		for _, entry := range entries {
+			if entry.BlockNumber != num {
+				kept = append(kept, entry)
+			}
+		}
+
+		if len(kept) > 0 {
+			if err := db.putIndex(batch, accountTxKey(accountID, bucket), kept); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := db.deleteIndex(batch, accountTxKey(accountID, bucket)); err != nil {
+			return err
+		}
+		if err := db.updateBuckets(batch, accountTxBucketsKey(accountID), bucket, false); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// accountTxEntries reads the transactions of the account for the specified
+// bucket, including the changes in the batch.
+func (db *Database) accountTxEntries(batch MetaBatch, accountID AccountID, bucket uint64) ([]AccountTx, error) {
+	var entries []AccountTx
+	if err := db.getIndex(batch, accountTxKey(accountID, bucket), &entries); err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// accountTxBucketsKey forms the key for the set of buckets holding
+// transactions for the account.
+func accountTxBucketsKey(accountID AccountID) string {
+	return accountTxPrefix + string(accountID)
+}
+
+// accountTxKey forms the key for the transactions of the account in the
+// bucket.
+func accountTxKey(accountID AccountID, bucket uint64) string {
+	return accountTxPrefix + string(accountID) + "/" + strconv.FormatUint(bucket, 10)
+}
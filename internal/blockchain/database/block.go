@@ -2,13 +2,17 @@ package database
 
 import (
 	"context"
+	"crypto/ecdsa"
 	"crypto/rand"
 	"errors"
 	"fmt"
 	"math"
 	"math/big"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/opplieam/bund-blockchain/internal/blockchain/events"
 	"github.com/opplieam/bund-blockchain/internal/blockchain/merkle"
 	"github.com/opplieam/bund-blockchain/internal/utils/signature"
 )
@@ -17,19 +21,35 @@ import (
 // is two or more blocks ahead of ours.
 var ErrChainForked = errors.New("blockchain forked, start resync")
 
+// Set of errors returned by block validation identifying the check that
+// failed. The specific failure is wrapped around them.
+var (
+	ErrInvalidDifficulty   = errors.New("invalid difficulty")
+	ErrInvalidHash         = errors.New("invalid block hash")
+	ErrInvalidNumber       = errors.New("invalid block number")
+	ErrInvalidTimestamp    = errors.New("invalid timestamp")
+	ErrInvalidMerkleRoot   = errors.New("invalid merkle root")
+	ErrInvalidStateRoot    = errors.New("invalid state root")
+	ErrTxExpired           = errors.New("transaction expired")
+	ErrInvalidSigner       = errors.New("invalid block signer")
+	ErrInvalidMiningReward = errors.New("invalid mining reward")
+)
+
 // BlockData represents what can be serialized to disk and over the network.
 type BlockData struct {
-	Hash   string      `json:"hash"`
-	Header BlockHeader `json:"block"`
-	Trans  []BlockTx   `json:"trans"`
+	Hash     string      `json:"hash"`
+	Header   BlockHeader `json:"block"`
+	Trans    []BlockTx   `json:"trans"`
+	Receipts []Receipt   `json:"receipts,omitempty"`
 }
 
 // NewBlockData constructs block data from a block.
 func NewBlockData(block Block) BlockData {
 	blockData := BlockData{
-		Hash:   block.Hash(),
-		Header: block.Header,
-		Trans:  block.MerkleTree.Values(),
+		Hash:     block.Hash(),
+		Header:   block.Header,
+		Trans:    block.MerkleTree.Values(),
+		Receipts: block.Receipts,
 	}
 
 	return blockData
@@ -45,6 +65,7 @@ func ToBlock(blockData BlockData) (Block, error) {
 	block := Block{
 		Header:     blockData.Header,
 		MerkleTree: tree,
+		Receipts:   blockData.Receipts,
 	}
 
 	return block, nil
@@ -61,28 +82,72 @@ type BlockHeader struct {
 	StateRoot     string    `json:"state_root"`      // Ethereum: Represents a hash of the accounts and their balances.
 	TransRoot     string    `json:"trans_root"`      // Both: Represents the merkle tree root hash for the transactions in this block.
 	Nonce         uint64    `json:"nonce"`           // Both: Value identified to solve the hash solution.
+
+	// The signature of the validator who produced the block under POA. The
+	// fields are left out of the JSON for POW blocks.
+	V *big.Int `json:"v,omitempty"` // Ethereum: Recovery identifier, either 29 or 30 with bundID.
+	R *big.Int `json:"r,omitempty"` // Ethereum: First coordinate of the ECDSA signature.
+	S *big.Int `json:"s,omitempty"` // Ethereum: Second coordinate of the ECDSA signature.
 }
 
 // Block represents a group of transactions batched together.
 type Block struct {
 	Header     BlockHeader
 	MerkleTree *merkle.Tree[BlockTx]
+	Receipts   []Receipt
 }
 
 // POWArgs represents the set of arguments required to run POW.
 type POWArgs struct {
 	BeneficiaryID AccountID
 	Difficulty    uint16
+	MinTimeStamp  uint64 // Lowest timestamp in milliseconds the block can have.
 	MiningReward  uint64
 	PrevBlock     Block
 	StateRoot     string
 	Trans         []BlockTx
-	EvHandler     func(v string, args ...any)
+	Workers       int // Number of goroutines searching for the nonce.
+	EvHandler     events.Handler
 }
 
 // POW constructs a new Block and performs the work to find a nonce that
 // solves the cryptographic POW puzzel.
 func POW(ctx context.Context, args POWArgs) (Block, error) {
+	block, err := newBlock(args)
+	if err != nil {
+		return Block{}, err
+	}
+
+	// Perform the proof of work mining operation.
+	if err := block.performPOW(ctx, args.Workers, args.EvHandler); err != nil {
+		return Block{}, err
+	}
+
+	return block, nil
+}
+
+// POA constructs a new Block and signs it with the validator's private key.
+// There is no work to perform, so the difficulty is always 0.
+func POA(args POWArgs, privateKey *ecdsa.PrivateKey) (Block, error) {
+	args.Difficulty = 0
+
+	block, err := newBlock(args)
+	if err != nil {
+		return Block{}, err
+	}
+
+	if err := block.Sign(privateKey); err != nil {
+		return Block{}, err
+	}
+
+	args.EvHandler(events.Event{Subsystem: "database", Op: "POA", Msg: "signed", BlockNumber: block.Header.Number})
+
+	return block, nil
+}
+
+// newBlock constructs the block for the transactions on top of the previous
+// block.
+func newBlock(args POWArgs) (Block, error) {
 	// When mining the first block, the previous block's hash will be zero.
 	prevBlockHash := signature.ZeroHash
 	if args.PrevBlock.Header.Number > 0 {
@@ -99,7 +164,7 @@ func POW(ctx context.Context, args POWArgs) (Block, error) {
 		Header: BlockHeader{
 			Number:        args.PrevBlock.Header.Number + 1,
 			PrevBlockHash: prevBlockHash,
-			TimeStamp:     uint64(time.Now().UTC().UnixMilli()),
+			TimeStamp:     max(uint64(time.Now().UTC().UnixMilli()), args.MinTimeStamp),
 			BeneficiaryID: args.BeneficiaryID,
 			Difficulty:    args.Difficulty,
 			MiningReward:  args.MiningReward,
@@ -109,60 +174,126 @@ func POW(ctx context.Context, args POWArgs) (Block, error) {
 		},
 		MerkleTree: tree,
 	}
-	// Perform the proof of work mining operation.
-	if err := block.performPOW(ctx, args.EvHandler); err != nil {
-		return Block{}, err
-	}
 
 	return block, nil
 }
 
 // performPOW does the work of mining to find a valid hash for a specified
 // block. Pointer semantics are being used since a nonce is being discovered.
-func (b Block) performPOW(ctx context.Context, ev func(v string, args ...any)) error {
-	ev("database: PerformPOW: MINING: started")
-	defer ev("database: PerformPOW: MINING: completed")
+// The search is split between the specified number of workers.
+func (b *Block) performPOW(ctx context.Context, workers int, ev events.Handler) error {
+	ev(events.Event{Subsystem: "database", Op: "PerformPOW", Msg: "started", BlockNumber: b.Header.Number})
+	defer ev(events.Event{Subsystem: "database", Op: "PerformPOW", Msg: "completed", BlockNumber: b.Header.Number})
 
 	// Log the transactions that are a part of this potential block.
 	for _, tx := range b.MerkleTree.Values() {
-		ev("database: PerformPOW: MINING: tx[%s]", tx)
+		ev(events.Event{Subsystem: "database", Op: "PerformPOW", Msg: "mining tx", BlockNumber: b.Header.Number, TxKey: tx.String()})
 	}
 
+	// CORE NOTE: Every worker gets its own copy of the header to hash. The
+	// nonce space is partitioned by having each worker start at the random
+	// starting point plus its index and step by the number of workers, so no
+	// two workers try the same nonce. The first worker to find a solution
+	// cancels the search, which stops the others on their next attempt.
+
 	// Choose a random starting point for the nonce. After this, the nonce
-	// will be incremented by 1 until a solution is found by us or another node.
+	// will be incremented until a solution is found by us or another node.
 	nBig, err := rand.Int(rand.Reader, big.NewInt(math.MaxInt64))
 	if err != nil {
 		return ctx.Err()
 	}
-	b.Header.Nonce = nBig.Uint64()
+	startNonce := nBig.Uint64()
+
+	workers = max(workers, 1)
+
+	ev(events.Event{Subsystem: "database", Op: "PerformPOW", Msg: fmt.Sprintf("running: workers[%d]", workers), BlockNumber: b.Header.Number})
+
+	// The search is cancelled when we or another node finds a solution.
+	searchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// The number of hashes tried and the time it took are reported when
+	// the search ends.
+	start := time.Now()
+
+	var attempts atomic.Uint64
+	var solved sync.Once
+	var solution BlockHeader
+	var found bool
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := range workers {
+		go func(header BlockHeader) {
+			defer wg.Done()
+
+			header.Nonce = startNonce + uint64(i)
+			tried := b.searchNonce(searchCtx, &header, uint64(workers), &attempts, ev)
+			attempts.Add(tried)
+
+			if searchCtx.Err() != nil {
+				return
+			}
+
+			solved.Do(func() {
+				solution = header
+				found = true
+				cancel()
+			})
+		}(b.Header)
+	}
+	wg.Wait()
+
+	total := attempts.Load()
+	elapsed := time.Since(start)
+	hashRate := float64(total) / elapsed.Seconds()
+
+	// Did we timeout trying to solve the problem.
+	if !found {
+		ev(events.Event{Kind: events.KindPowCompleted, Subsystem: "database", Op: "PerformPOW", Msg: fmt.Sprintf("cancelled: attempts[%d]: hashRate[%.0f/s]", total, hashRate), BlockNumber: b.Header.Number, Duration: elapsed, Err: ctx.Err(), Data: total})
+		return ctx.Err()
+	}
+
+	b.Header = solution
+
+	ev(events.Event{Kind: events.KindPowCompleted, Subsystem: "database", Op: "PerformPOW", Msg: fmt.Sprintf("solved: prevBlk[%s]: newBlk[%s]: attempts[%d]: hashRate[%.0f/s]", b.Header.PrevBlockHash, b.Hash(), total, hashRate), BlockNumber: b.Header.Number, Duration: elapsed, Data: total})
+
+	return nil
+}
 
-	ev("viewer: PerformPOW: MINING: running")
+// searchNonce hashes the header, moving the nonce forward by the step, until
+// the puzzle is solved or the search is cancelled. The number of hashes tried
+// that haven't been added to the shared count is returned.
+func (b *Block) searchNonce(ctx context.Context, header *BlockHeader, step uint64, attempts *atomic.Uint64, ev events.Handler) uint64 {
+	const batch = 1_000
 
-	// Loop until we or another node finds a solution for the next block.
-	var attempts uint64
+	// Checking the done channel is cheap enough to do on every attempt.
+	done := ctx.Done()
+
+	var tried uint64
 	for {
-		attempts++
-		if attempts%1_000_000 == 0 {
-			ev("viewer: PerformPOW: MINING: running: attempts[%d]", attempts)
+		select {
+		case <-done:
+			return tried
+		default:
 		}
 
-		// Did we timeout trying to solve the problem.
-		if ctx.Err() != nil {
-			ev("database: PerformPOW: MINING: CANCELLED")
-			return ctx.Err()
+		// Adding to the shared count on every attempt would have the workers
+		// fighting over it.
+		tried++
+		if tried == batch {
+			if total := attempts.Add(batch); total%1_000_000 == 0 {
+				ev(events.Event{Subsystem: "database", Op: "PerformPOW", Msg: fmt.Sprintf("running: attempts[%d]", total), BlockNumber: header.Number})
+			}
+			tried = 0
 		}
 
 		// Hash the block and check if we have solved the puzzle.
-		hash := b.Hash()
-		if !isHashSolved(b.Header.Difficulty, hash) {
-			b.Header.Nonce++
-			continue
+		if isHashSolved(header.Difficulty, signature.Hash(*header)) {
+			return tried
 		}
 
-		ev("database: PerformPOW: MINING: SOLVED: prevBlk[%s]: newBlk[%s]", b.Header.PrevBlockHash, hash)
-		ev("database: PerformPOW: MINING: attempts[%d]", attempts)
-
-		return nil
+		header.Nonce += step
 	}
 }
 
@@ -186,9 +317,82 @@ func (b Block) Hash() string {
 	return signature.Hash(b.Header)
 }
 
+// Sign uses the specified private key to sign the block header. The signature
+// covers every header field except the signature itself.
+func (b *Block) Sign(privateKey *ecdsa.PrivateKey) error {
+	v, r, s, err := signature.Sign(b.Header.unsigned(), privateKey)
+	if err != nil {
+		return err
+	}
+
+	b.Header.V = v
+	b.Header.R = r
+	b.Header.S = s
+
+	return nil
+}
+
+// Signer returns the account that signed the block header.
+func (b Block) Signer() (AccountID, error) {
+	if b.Header.V == nil || b.Header.R == nil || b.Header.S == nil {
+		return "", errors.New("block is not signed")
+	}
+
+	if err := signature.VerifySignature(b.Header.V, b.Header.R, b.Header.S); err != nil {
+		return "", err
+	}
+
+	address, err := signature.FromAddress(b.Header.unsigned(), b.Header.V, b.Header.R, b.Header.S)
+	if err != nil {
+		return "", err
+	}
+
+	return AccountID(address), nil
+}
+
+// unsigned returns a copy of the header without the signature.
+func (bh BlockHeader) unsigned() BlockHeader {
+	bh.V = nil
+	bh.R = nil
+	bh.S = nil
+
+	return bh
+}
+
+// Work returns the amount of work required to solve the block. Each level of
+// difficulty adds another hex 0 to the solution, making it 16 times harder.
+func (b Block) Work() *big.Int {
+	return new(big.Int).Exp(big.NewInt(16), big.NewInt(int64(b.Header.Difficulty)), nil)
+}
+
 // ValidateBlock takes a block and validates it to be included into the blockchain.
-func (b Block) ValidateBlock(previousBlock Block, stateRoot string, evHandler func(v string, args ...any)) error {
-	evHandler("database: ValidateBlock: validate: blk[%d]: check: chain is not forked", b.Header.Number)
+func (b Block) ValidateBlock(previousBlock Block, stateRoot string, evHandler events.Handler) error {
+	if err := b.ValidateHeader(previousBlock, evHandler); err != nil {
+		return err
+	}
+
+	evHandler(events.Event{Subsystem: "database", Op: "ValidateBlock", Msg: "check: transactions have not expired", BlockNumber: b.Header.Number})
+
+	for _, tx := range b.MerkleTree.Values() {
+		if tx.Expired(b.Header.Number, b.Header.TimeStamp) {
+			return fmt.Errorf("%w: %s, expiry block %d, expiry time %d", ErrTxExpired, tx, tx.ExpiryBlock, tx.ExpiryTime)
+		}
+	}
+
+	evHandler(events.Event{Subsystem: "database", Op: "ValidateBlock", Msg: "check: state root hash does match current database", BlockNumber: b.Header.Number})
+
+	if b.Header.StateRoot != stateRoot {
+		return fmt.Errorf("%w: state of the accounts are wrong, current %s, expected %s", ErrInvalidStateRoot, stateRoot, b.Header.StateRoot)
+	}
+
+	return nil
+}
+
+// ValidateHeader validates the block against its parent block without the
+// need of the account state. This is used to validate blocks that belong
+// to a side branch of the chain.
+func (b Block) ValidateHeader(previousBlock Block, evHandler events.Handler) error {
+	evHandler(events.Event{Subsystem: "database", Op: "ValidateBlock", Msg: "check: chain is not forked", BlockNumber: b.Header.Number})
 
 	// The node who sent this block has a chain that is two or more blocks ahead
 	// of ours. This means there has been a fork and we are on the wrong side.
@@ -197,60 +401,59 @@ func (b Block) ValidateBlock(previousBlock Block, stateRoot string, evHandler fu
 		return ErrChainForked
 	}
 
-	evHandler("database: ValidateBlock: validate: blk[%d]: check: block difficulty is the same or greater than parent block difficulty", b.Header.Number)
+	evHandler(events.Event{Subsystem: "database", Op: "ValidateBlock", Msg: "check: block difficulty is in range", BlockNumber: b.Header.Number})
 
-	if b.Header.Difficulty < previousBlock.Header.Difficulty {
-		return fmt.Errorf("block difficulty is less than previous block difficulty, parent %d, block %d", previousBlock.Header.Difficulty, b.Header.Difficulty)
+	if b.Header.Difficulty > maxDifficulty {
+		return fmt.Errorf("%w: got %d, max %d", ErrInvalidDifficulty, b.Header.Difficulty, maxDifficulty)
 	}
 
-	evHandler("database: ValidateBlock: validate: blk[%d]: check: block hash has been solved", b.Header.Number)
+	evHandler(events.Event{Subsystem: "database", Op: "ValidateBlock", Msg: "check: block hash has been solved", BlockNumber: b.Header.Number})
 
 	hash := b.Hash()
 	if !isHashSolved(b.Header.Difficulty, hash) {
-		return fmt.Errorf("%s invalid block hash", hash)
+		return fmt.Errorf("%w: %s", ErrInvalidHash, hash)
 	}
 
-	evHandler("database: ValidateBlock: validate: blk[%d]: check: block number is the next number", b.Header.Number)
+	evHandler(events.Event{Subsystem: "database", Op: "ValidateBlock", Msg: "check: block number is the next number", BlockNumber: b.Header.Number})
 
 	if b.Header.Number != nextNumber {
-		return fmt.Errorf("this block is not the next number, got %d, exp %d", b.Header.Number, nextNumber)
+		return fmt.Errorf("%w: this block is not the next number, got %d, exp %d", ErrInvalidNumber, b.Header.Number, nextNumber)
 	}
 
-	evHandler("database: ValidateBlock: validate: blk[%d]: check: parent hash does match parent block", b.Header.Number)
+	evHandler(events.Event{Subsystem: "database", Op: "ValidateBlock", Msg: "check: parent hash does match parent block", BlockNumber: b.Header.Number})
 
+	// A block at the next number that doesn't build on our latest block means
+	// the node who sent it is on a different branch of the chain.
 	if b.Header.PrevBlockHash != previousBlock.Hash() {
-		return fmt.Errorf("parent block hash doesn't match our known parent, got %s, exp %s", b.Header.PrevBlockHash, previousBlock.Hash())
+		return fmt.Errorf("%w: parent block hash doesn't match our known parent, got %s, exp %s", ErrChainForked, b.Header.PrevBlockHash, previousBlock.Hash())
 	}
 
-	if previousBlock.Header.TimeStamp > 0 {
-		evHandler("database: ValidateBlock: validate: blk[%d]: check: block's timestamp is greater than parent block's timestamp", b.Header.Number)
-
-		parentTime := time.Unix(int64(previousBlock.Header.TimeStamp), 0)
-		blockTime := time.Unix(int64(b.Header.TimeStamp), 0)
-		if blockTime.Before(parentTime) {
-			return fmt.Errorf("block timestamp is before parent block, parent %s, block %s", parentTime, blockTime)
-		}
-
-		// This is a check that Ethereum does but we can't because we don't run all the time.
-
-		// evHandler("database: ValidateBlock: validate: blk[%d]: check: block is less than 15 minutes apart from parent block", b.Header.Number)
+	// The lower bound on the timestamp is the median time past, which needs
+	// the blocks before the parent and is checked by the database.
+	evHandler(events.Event{Subsystem: "database", Op: "ValidateBlock", Msg: "check: block's timestamp is not too far in the future", BlockNumber: b.Header.Number})
 
-		// dur := blockTime.Sub(parentTime)
-		// if dur.Seconds() > time.Duration(15*time.Second).Seconds() {
-		// 	return fmt.Errorf("block is older than 15 minutes, duration %v", dur)
-		// }
+	// Timestamps are in milliseconds.
+	blockTime := time.UnixMilli(int64(b.Header.TimeStamp)).UTC()
+	if limit := time.Now().UTC().Add(maxFutureDrift); blockTime.After(limit) {
+		return fmt.Errorf("%w: block timestamp is more than %s in the future, block %s", ErrInvalidTimestamp, maxFutureDrift, blockTime)
 	}
 
-	evHandler("database: ValidateBlock: validate: blk[%d]: check: state root hash does match current database", b.Header.Number)
+	evHandler(events.Event{Subsystem: "database", Op: "ValidateBlock", Msg: "check: merkle root does match transactions", BlockNumber: b.Header.Number})
 
-	if b.Header.StateRoot != stateRoot {
-		return fmt.Errorf("state of the accounts are wrong, current %s, expected %s", stateRoot, b.Header.StateRoot)
+	if b.Header.TransRoot != b.MerkleTree.RootHex() {
+		return fmt.Errorf("%w: merkle root does not match transactions, got %s, exp %s", ErrInvalidMerkleRoot, b.MerkleTree.RootHex(), b.Header.TransRoot)
 	}
 
-	evHandler("database: ValidateBlock: validate: blk[%d]: check: merkle root does match transactions", b.Header.Number)
+	return nil
+}
 
-	if b.Header.TransRoot != b.MerkleTree.RootHex() {
-		return fmt.Errorf("merkle root does not match transactions, got %s, exp %s", b.MerkleTree.RootHex(), b.Header.TransRoot)
+// VerifyPOW checks the hash of the block solves the POW puzzle for the
+// difficulty in the block header. Only the block header is required, so a
+// client holding headers can check the work without trusting a node.
+func (b Block) VerifyPOW() error {
+	hash := b.Hash()
+	if !isHashSolved(b.Header.Difficulty, hash) {
+		return fmt.Errorf("%w: %s", ErrInvalidHash, hash)
 	}
 
 	return nil
@@ -261,7 +464,7 @@ func (b Block) ValidateBlock(previousBlock Block, stateRoot string, evHandler fu
 func isHashSolved(difficulty uint16, hash string) bool {
 	const match = "0x00000000000000000"
 
-	if len(hash) != 66 {
+	if len(hash) != 66 || difficulty > maxDifficulty {
 		return false
 	}
 
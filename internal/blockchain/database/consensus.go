@@ -0,0 +1,106 @@
+package database
+
+import (
+	"fmt"
+
+	"github.com/opplieam/bund-blockchain/internal/blockchain/events"
+)
+
+// CORE NOTE: Under POA, the genesis file declares the set of validators that
+// are allowed to produce blocks. They take turns by block height, so the
+// validator for a block is found by the block number modulo the number of
+// validators. Instead of solving a POW puzzle, the validator signs the block
+// header with its private key and every node checks the signature belongs to
+// the validator whose turn it is. If that validator is offline, no block is
+// produced until it comes back.
+
+// Validators returns the accounts allowed to produce blocks under POA in the
+// order they take turns. The list is empty when the chain runs under POW.
+func (db *Database) Validators() []AccountID {
+	return append([]AccountID(nil), db.validators...)
+}
+
+// Validator returns the validator expected to produce the block with the
+// specified number. False is returned if the chain runs under POW.
+func (db *Database) Validator(number uint64) (AccountID, bool) {
+	if len(db.validators) == 0 {
+		return "", false
+	}
+
+	return db.validators[number%uint64(len(db.validators))], true
+}
+
+// ValidateBlock validates the block to become the next block in the chain.
+// Along with the checks the block can do on its own, the consensus rules that
+// depend on the chain are checked.
+func (db *Database) ValidateBlock(block Block, evHandler events.Handler) error {
+	parent := db.LatestBlock()
+
+	if err := block.ValidateBlock(parent, db.HashState(), evHandler); err != nil {
+		return err
+	}
+
+	return db.validateChainRules(block, parent, evHandler)
+}
+
+// ValidateSigner validates the block was signed by the validator expected
+// for its height. Blocks on a POW chain have no signer to check.
+func (db *Database) ValidateSigner(block Block) error {
+	validator, exists := db.Validator(block.Header.Number)
+	if !exists {
+		return nil
+	}
+
+	if block.Header.Difficulty != 0 {
+		return fmt.Errorf("%w: POA blocks have no difficulty, got %d", ErrInvalidDifficulty, block.Header.Difficulty)
+	}
+
+	signer, err := block.Signer()
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidSigner, err)
+	}
+
+	if signer != validator {
+		return fmt.Errorf("%w: got %s, exp %s", ErrInvalidSigner, signer, validator)
+	}
+
+	return nil
+}
+
+// =============================================================================
+
+// validateChainRules validates the block follows the rules that depend on
+// the blocks before it or the genesis file. The timestamp must be after the
+// median time past and the mining reward must match the schedule. Under POA
+// the block must be signed by the expected validator, under POW it must have
+// the expected difficulty.
+func (db *Database) validateChainRules(block Block, parent Block, evHandler events.Handler) error {
+	if err := db.validateTimestamp(block, parent, evHandler); err != nil {
+		return err
+	}
+
+	evHandler(events.Event{Subsystem: "database", Op: "ValidateBlock", Msg: "check: mining reward matches the schedule", BlockNumber: block.Header.Number})
+
+	if err := db.validateMiningReward(block); err != nil {
+		return err
+	}
+
+	if len(db.validators) > 0 {
+		evHandler(events.Event{Subsystem: "database", Op: "ValidateBlock", Msg: "check: block is signed by the expected validator", BlockNumber: block.Header.Number})
+
+		return db.ValidateSigner(block)
+	}
+
+	evHandler(events.Event{Subsystem: "database", Op: "ValidateBlock", Msg: "check: block difficulty is the expected difficulty", BlockNumber: block.Header.Number})
+
+	difficulty, err := db.ExpectedDifficulty(parent)
+	if err != nil {
+		return err
+	}
+
+	if block.Header.Difficulty != difficulty {
+		return fmt.Errorf("%w: got %d, exp %d", ErrInvalidDifficulty, block.Header.Difficulty, difficulty)
+	}
+
+	return nil
+}
@@ -3,23 +3,38 @@ package database
 import (
 	"errors"
 	"fmt"
+	"io/fs"
+	"math/big"
 	"sort"
 	"sync"
 
+	"github.com/opplieam/bund-blockchain/internal/blockchain/events"
 	"github.com/opplieam/bund-blockchain/internal/blockchain/genesis"
 	"github.com/opplieam/bund-blockchain/internal/utils/signature"
 )
 
 // Storage interface represents the behavior required to be implemented by any
-// package providing support for reading and writing the blockchain.
+// package providing support for reading and writing the blockchain. The meta
+// functions store data the database maintains alongside the blocks, such as
+// indexes. GetMeta must return an error wrapping fs.ErrNotExist when the key
+// doesn't exist. WriteMeta must apply every change in the batch together.
 type Storage interface {
 	Write(blockData BlockData) error
 	GetBlock(num uint64) (BlockData, error)
 	ForEach() Iterator
+	Rollback(num uint64) error
+	PutMeta(key string, value []byte) error
+	GetMeta(key string) ([]byte, error)
+	DeleteMeta(key string) error
+	WriteMeta(batch MetaBatch) error
 	Close() error
 	Reset() error
 }
 
+// MetaBatch represents a set of changes to the meta data that are written
+// together. A nil value deletes the key.
+type MetaBatch map[string][]byte
+
 // Iterator interface represents the behavior required to be implemented by any
 // package providing support to iterate over the blocks.
 type Iterator interface {
@@ -34,18 +49,39 @@ type Database struct {
 	mu          sync.RWMutex
 	genesis     genesis.Genesis
 	latestBlock Block
+	totalWork   *big.Int
 	accounts    map[AccountID]Account
+	undo        map[uint64]undoLog
+	sideBlocks  map[string]Block
+	indexHeight uint64
 	storage     Storage
+	validators  []AccountID
+
+	snapshotInterval uint64
+	snapshots        []uint64
+	fullReplay       bool
 }
 
 // New constructs a new database and applies account genesis information and
 // reads/writes the blockchain database on disk if a dbPath is provided.
-func New(genesis genesis.Genesis, storage Storage, evHandler func(v string, args ...any)) (*Database, error) {
+func New(genesis genesis.Genesis, storage Storage, evHandler events.Handler, options ...func(db *Database)) (*Database, error) {
 	db := Database{
-		genesis:  genesis,
-		accounts: make(map[AccountID]Account),
-		storage:  storage,
+		genesis:    genesis,
+		totalWork:  big.NewInt(0),
+		accounts:   make(map[AccountID]Account),
+		undo:       make(map[uint64]undoLog),
+		sideBlocks: make(map[string]Block),
+		storage:    storage,
+	}
+
+	for _, option := range options {
+		option(&db)
+	}
+
+	if genesis.FeeBurnPercent > 100 {
+		return nil, fmt.Errorf("genesis fee burn percent %d is more than 100", genesis.FeeBurnPercent)
 	}
+
 	// Update the database with account balance information from genesis.
 	for accountStr, balance := range genesis.Balances {
 		accountID, err := ToAccountID(accountStr)
@@ -54,18 +90,46 @@ func New(genesis genesis.Genesis, storage Storage, evHandler func(v string, args
 		}
 		db.accounts[accountID] = newAccount(accountID, balance)
 
-		evHandler("Account: %s, Balance: %d", accountID, balance)
+		evHandler(events.Event{Subsystem: "database", Op: "New", Msg: fmt.Sprintf("genesis account[%s]: balance[%d]", accountID, balance)})
 	}
 
-	// Read all the blocks from storage.
-	iter := db.ForEach()
-	for block, err := iter.Next(); !iter.Done(); block, err = iter.Next() {
+	// The validators that take turns producing blocks under POA.
+	for _, validator := range genesis.Validators {
+		accountID, err := ToAccountID(validator)
 		if err != nil {
+			return nil, fmt.Errorf("genesis validator: %w", err)
+		}
+		db.validators = append(db.validators, accountID)
+	}
+
+	// POA blocks have no difficulty, so it only needs to be in range for POW.
+	if len(db.validators) == 0 && (genesis.Difficulty < minDifficulty || genesis.Difficulty > maxDifficulty) {
+		return nil, fmt.Errorf("genesis difficulty %d is not between %d and %d", genesis.Difficulty, minDifficulty, maxDifficulty)
+	}
+
+	// Find out how far the blocks have been indexed.
+	if err := db.loadIndexHeight(); err != nil {
+		return nil, err
+	}
+
+	// Start from the newest usable snapshot of the accounts, if any.
+	from, err := db.loadSnapshot(evHandler)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read the blocks after the snapshot from storage.
+	for num := from + 1; ; num++ {
+		block, err := db.GetBlock(num)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				break
+			}
 			return nil, err
 		}
 
 		// Validate the block values and cryptographic audit trail.
-		if err := block.ValidateBlock(db.latestBlock, db.HashState(), evHandler); err != nil {
+		if err := db.ValidateBlock(block, evHandler); err != nil {
 			return nil, err
 		}
 
@@ -77,6 +141,25 @@ func New(genesis genesis.Genesis, storage Storage, evHandler func(v string, args
 
 		// Update the current latest block.
 		db.latestBlock = block
+		db.totalWork.Add(db.totalWork, block.Work())
+
+		// Index any block that didn't make it into the index.
+		if block.Header.Number > db.indexHeight {
+			if err := db.indexBlock(block); err != nil {
+				return nil, err
+			}
+		}
+
+		if err := db.WriteSnapshot(); err != nil {
+			return nil, err
+		}
+	}
+
+	// The index can't be ahead of the chain.
+	if db.indexHeight > db.latestBlock.Header.Number {
+		if err := db.setIndexHeight(db.latestBlock.Header.Number); err != nil {
+			return nil, err
+		}
 	}
 
 	return &db, nil
@@ -97,7 +180,7 @@ func (db *Database) Query(accountID AccountID) (Account, error) {
 
 	account, exists := db.accounts[accountID]
 	if !exists {
-		return Account{}, errors.New("account does not exist")
+		return Account{}, ErrAccountNotFound
 	}
 
 	return account, nil
@@ -136,6 +219,8 @@ func (db *Database) ApplyMiningReward(block Block) {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
+	db.recordUndo(block.Header.Number, block.Header.BeneficiaryID)
+
 	account := db.accounts[block.Header.BeneficiaryID]
 	account.Balance += block.Header.MiningReward
 
@@ -143,11 +228,15 @@ func (db *Database) ApplyMiningReward(block Block) {
 }
 
 // ApplyTransaction performs the business logic for applying a transaction
-// to the database.
-func (db *Database) ApplyTransaction(block Block, tx BlockTx) error {
+// to the database. The gas fee taken from the sender is returned, even when
+// the transaction fails.
+func (db *Database) ApplyTransaction(block Block, tx BlockTx) (uint64, error) {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
+	// Keep the original values of these accounts so the block can be undone.
+	db.recordUndo(block.Header.Number, tx.FromID, tx.ToID, block.Header.BeneficiaryID)
+
 	// Capture these accounts from the database.
 	from, exists := db.accounts[tx.FromID]
 	if !exists {
@@ -172,7 +261,7 @@ func (db *Database) ApplyTransaction(block Block, tx BlockTx) error {
 		gasFee = from.Balance
 	}
 	from.Balance -= gasFee
-	bnfc.Balance += gasFee
+	bnfc.Balance += gasFee - db.burnedFee(gasFee)
 
 	// Make sure these changes get applied.
 	db.accounts[tx.FromID] = from
@@ -181,11 +270,11 @@ func (db *Database) ApplyTransaction(block Block, tx BlockTx) error {
 	// Perform basic accounting checks.
 	{
 		if tx.Nonce != (from.Nonce + 1) {
-			return fmt.Errorf("transaction invalid, wrong nonce, got %d, exp %d", tx.Nonce, from.Nonce+1)
+			return gasFee, fmt.Errorf("transaction invalid, wrong nonce, got %d, exp %d", tx.Nonce, from.Nonce+1)
 		}
 
 		if from.Balance == 0 || from.Balance < (tx.Value+tx.Tip) {
-			return fmt.Errorf("transaction invalid, insufficient funds, bal %d, needed %d", from.Balance, (tx.Value + tx.Tip))
+			return gasFee, fmt.Errorf("transaction invalid, insufficient funds, bal %d, needed %d", from.Balance, (tx.Value + tx.Tip))
 		}
 	}
 
@@ -205,7 +294,7 @@ func (db *Database) ApplyTransaction(block Block, tx BlockTx) error {
 	db.accounts[tx.ToID] = to
 	db.accounts[block.Header.BeneficiaryID] = bnfc
 
-	return nil
+	return gasFee, nil
 }
 
 // UpdateLatestBlock provides safe access to update the latest block.
@@ -214,6 +303,64 @@ func (db *Database) UpdateLatestBlock(block Block) {
 	defer db.mu.Unlock()
 
 	db.latestBlock = block
+	db.totalWork.Add(db.totalWork, block.Work())
+
+	// The block could have been sitting on a side branch.
+	delete(db.sideBlocks, block.Hash())
+}
+
+// RollbackLatestBlock removes the latest block from the chain and restores
+// the accounts to the values they held before the block was applied. The
+// block that was removed is returned so its transactions can be recovered.
+func (db *Database) RollbackLatestBlock() (Block, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	block := db.latestBlock
+	num := block.Header.Number
+	if num == 0 {
+		return Block{}, errors.New("no blocks to roll back")
+	}
+
+	if _, exists := db.undo[num]; !exists {
+		return Block{}, fmt.Errorf("no undo information for block %d", num)
+	}
+
+	// Locate the parent block before anything is changed. Block 0 is the
+	// genesis and is represented by the zero value block.
+	var prevBlock Block
+	if num > 1 {
+		var err error
+		if prevBlock, err = db.GetBlock(num - 1); err != nil {
+			return Block{}, err
+		}
+	}
+
+	// Take the block out of the index and drop its snapshot before it's
+	// removed from storage, so a failure never leaves them pointing at a
+	// block that is gone. If the storage fails after this, the block stays
+	// the latest block with the index behind it, which is indexed again on
+	// the next start. The block might not have made it into the index.
+	if num <= db.indexHeight {
+		if err := db.unindexBlock(block); err != nil {
+			return Block{}, err
+		}
+	}
+
+	if err := db.dropSnapshot(num); err != nil {
+		return Block{}, err
+	}
+
+	if err := db.storage.Rollback(num); err != nil {
+		return Block{}, err
+	}
+
+	// Restore the accounts to their value prior to this block.
+	db.restoreAccounts(num)
+	db.latestBlock = prevBlock
+	db.totalWork.Sub(db.totalWork, block.Work())
+
+	return block, nil
 }
 
 // LatestBlock returns the latest block.
@@ -224,9 +371,53 @@ func (db *Database) LatestBlock() Block {
 	return db.latestBlock
 }
 
-// Write adds a new block to the chain.
-func (db *Database) Write(block Block) error {
-	return db.storage.Write(NewBlockData(block))
+// TotalWork returns the cumulative work of all the blocks in the chain.
+func (db *Database) TotalWork() *big.Int {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return new(big.Int).Set(db.totalWork)
+}
+
+// WorkAfter returns the cumulative work of the blocks in the chain after
+// the specified block number.
+func (db *Database) WorkAfter(num uint64) (*big.Int, error) {
+	work := big.NewInt(0)
+	for i := db.LatestBlock().Header.Number; i > num; i-- {
+		block, err := db.GetBlock(i)
+		if err != nil {
+			return nil, err
+		}
+		work.Add(work, block.Work())
+	}
+
+	return work, nil
+}
+
+// Write adds a new block to the chain and indexes it. The index can be
+// rebuilt from the blocks, so a failure to index the block doesn't fail the
+// write. The index stops at the block before and is brought up to date on
+// the next start.
+func (db *Database) Write(block Block, evHandler events.Handler) error {
+	if err := db.storage.Write(NewBlockData(block)); err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// Once a block is missing from the index, the blocks after it can't be
+	// indexed until the index is rebuilt.
+	if block.Header.Number != db.indexHeight+1 {
+		evHandler(events.Event{Kind: events.KindWarning, Subsystem: "database", Op: "Write", Msg: fmt.Sprintf("index is behind at block %d: rebuilt on restart", db.indexHeight), BlockNumber: block.Header.Number})
+		return nil
+	}
+
+	if err := db.indexBlock(block); err != nil {
+		evHandler(events.Event{Kind: events.KindWarning, Subsystem: "database", Op: "Write", Msg: "index block: rebuilt on restart", BlockNumber: block.Header.Number, Err: err})
+	}
+
+	return nil
 }
 
 // ForEach returns an iterator to walk through all the blocks
@@ -0,0 +1,79 @@
+package database
+
+import (
+	"fmt"
+)
+
+// CORE NOTE: Under POW, the difficulty is adjusted every retarget interval
+// blocks so blocks keep coming at the target block time as miners join and
+// leave. At the start of each interval, the time the previous interval took
+// is compared with the time it should have taken. Each level of difficulty
+// makes the puzzle 16 times harder, so the difficulty only moves one level
+// when the blocks came more than 4 times faster or slower than the target,
+// which is halfway between two levels. The difficulty for a height depends
+// only on the blocks before it, so every node computes the same value.
+
+// Set of bounds for the difficulty.
+const (
+	minDifficulty = 1
+	maxDifficulty = 16
+)
+
+// retargetFactor is how far off the target the block time needs to be to
+// move the difficulty one level.
+const retargetFactor = 4
+
+// ExpectedDifficulty returns the difficulty required for the block that
+// builds on the specified parent block. The parent can be on the main chain
+// or a side branch.
+func (db *Database) ExpectedDifficulty(parent Block) (uint16, error) {
+	number := parent.Header.Number + 1
+	if number == 1 {
+		return db.genesis.Difficulty, nil
+	}
+
+	// The difficulty only changes on the first block of an interval.
+	interval := db.genesis.RetargetInterval
+	target := db.genesis.TargetBlockTime
+	if interval < 2 || target == 0 || (number-1)%interval != 0 {
+		return parent.Header.Difficulty, nil
+	}
+
+	first, err := db.ancestor(parent, number-interval)
+	if err != nil {
+		return 0, fmt.Errorf("retarget: %w", err)
+	}
+
+	// Timestamps are in milliseconds.
+	var actual uint64
+	if parent.Header.TimeStamp > first.Header.TimeStamp {
+		actual = parent.Header.TimeStamp - first.Header.TimeStamp
+	}
+	expected := (interval - 1) * target * 1000
+
+	difficulty := parent.Header.Difficulty
+	switch {
+	case actual*retargetFactor < expected && difficulty < maxDifficulty:
+		difficulty++
+	case actual > expected*retargetFactor && difficulty > minDifficulty:
+		difficulty--
+	}
+
+	return difficulty, nil
+}
+
+// =============================================================================
+
+// ancestor walks back from the block to the block with the specified number
+// on the same branch.
+func (db *Database) ancestor(block Block, number uint64) (Block, error) {
+	for block.Header.Number > number {
+		parent, err := db.findParent(block)
+		if err != nil {
+			return Block{}, err
+		}
+		block = parent
+	}
+
+	return block, nil
+}
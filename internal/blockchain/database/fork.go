@@ -0,0 +1,168 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/opplieam/bund-blockchain/internal/blockchain/events"
+	"github.com/opplieam/bund-blockchain/internal/utils/signature"
+)
+
+// CORE NOTE: When two miners solve a block for the same height, the network
+// ends up with two competing branches. Blocks that don't build on our latest
+// block, but do build on a block we know about, are kept as side blocks. Just
+// like Bitcoin, the branch with the most cumulative work wins. Once a side
+// branch holds more work than the main chain, the node switches over to it.
+
+// SideBranch represents a branch of blocks that forks off the main chain.
+type SideBranch struct {
+	Ancestor uint64   // Number of the main chain block the branch builds on.
+	Blocks   []Block  // Blocks in the branch ordered from the ancestor forward.
+	Work     *big.Int // Cumulative work of the blocks in the branch.
+}
+
+// AddSideBlock validates the block against its parent and keeps it as part
+// of a side branch. The parent must be a block on the main chain or a known
+// side block.
+func (db *Database) AddSideBlock(block Block, evHandler events.Handler) error {
+	hash := block.Hash()
+
+	if main, err := db.GetBlock(block.Header.Number); err == nil && main.Hash() == hash {
+		return fmt.Errorf("block %s already in chain", hash)
+	}
+
+	parent, err := db.findParent(block)
+	if err != nil {
+		return err
+	}
+
+	if err := block.ValidateHeader(parent, evHandler); err != nil {
+		return err
+	}
+	if err := db.validateChainRules(block, parent, evHandler); err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, exists := db.sideBlocks[hash]; exists {
+		return fmt.Errorf("block %s already known", hash)
+	}
+	db.sideBlocks[hash] = block
+
+	// Side blocks deeper than we are willing to reorganize are of no use.
+	if latest := db.latestBlock.Header.Number; latest > MaxReorgDepth {
+		for sideHash, sideBlock := range db.sideBlocks {
+			if sideBlock.Header.Number <= latest-MaxReorgDepth {
+				delete(db.sideBlocks, sideHash)
+			}
+		}
+	}
+
+	return nil
+}
+
+// SideBlock returns the side block with the specified hash.
+func (db *Database) SideBlock(hash string) (Block, bool) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	block, exists := db.sideBlocks[hash]
+	return block, exists
+}
+
+// SideBranch walks back from the specified side block to the main chain and
+// returns the branch that block is the tip of.
+func (db *Database) SideBranch(hash string) (SideBranch, error) {
+	var blocks []Block
+	db.mu.RLock()
+	{
+		for {
+			block, exists := db.sideBlocks[hash]
+			if !exists {
+				break
+			}
+			blocks = append(blocks, block)
+			hash = block.Header.PrevBlockHash
+		}
+	}
+	db.mu.RUnlock()
+
+	if len(blocks) == 0 {
+		return SideBranch{}, errors.New("block is not on a side branch")
+	}
+
+	// Order the blocks from the ancestor forward.
+	for i, j := 0, len(blocks)-1; i < j; i, j = i+1, j-1 {
+		blocks[i], blocks[j] = blocks[j], blocks[i]
+	}
+
+	// The first block of the branch must build on the main chain.
+	ancestor := blocks[0].Header.Number - 1
+	if _, err := db.mainBlock(ancestor, blocks[0].Header.PrevBlockHash); err != nil {
+		return SideBranch{}, fmt.Errorf("branch does not connect to the chain: %w", err)
+	}
+
+	work := big.NewInt(0)
+	for _, block := range blocks {
+		work.Add(work, block.Work())
+	}
+
+	sb := SideBranch{
+		Ancestor: ancestor,
+		Blocks:   blocks,
+		Work:     work,
+	}
+
+	return sb, nil
+}
+
+// =============================================================================
+
+// findParent locates the parent of the specified block on the main chain or
+// in the set of side blocks.
+func (db *Database) findParent(block Block) (Block, error) {
+	db.mu.RLock()
+	parent, exists := db.sideBlocks[block.Header.PrevBlockHash]
+	db.mu.RUnlock()
+
+	if exists {
+		return parent, nil
+	}
+
+	parent, err := db.mainBlock(block.Header.Number-1, block.Header.PrevBlockHash)
+	if err != nil {
+		return Block{}, fmt.Errorf("%w: unknown parent block %s", ErrChainForked, block.Header.PrevBlockHash)
+	}
+
+	return parent, nil
+}
+
+// mainBlock returns the main chain block for the specified number as long
+// as it matches the specified hash. Block 0 is the genesis block and is
+// represented by the zero value block.
+func (db *Database) mainBlock(num uint64, hash string) (Block, error) {
+	if num == 0 {
+		if hash != signature.ZeroHash {
+			return Block{}, errors.New("genesis hash mismatch")
+		}
+		return Block{}, nil
+	}
+
+	if num > db.LatestBlock().Header.Number {
+		return Block{}, fmt.Errorf("block %d not in chain", num)
+	}
+
+	block, err := db.GetBlock(num)
+	if err != nil {
+		return Block{}, err
+	}
+
+	if block.Hash() != hash {
+		return Block{}, fmt.Errorf("block %d hash mismatch", num)
+	}
+
+	return block, nil
+}
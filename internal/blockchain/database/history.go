@@ -0,0 +1,167 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// CORE NOTE: To answer what an account looked like at a given block, the
+// value of every account changed by a block is kept as part of the index.
+// The values are grouped per account into buckets of block numbers so a
+// busy account, like a miner's, doesn't turn every block into rewriting its
+// whole history. The accounts changed by a block are the ones recorded in
+// the undo information for the block.
+
+// historyPrefix is the prefix for the keys used to store account history.
+const historyPrefix = "history/"
+
+// historyEntry represents the value of an account after the specified block.
+type historyEntry struct {
+	BlockNumber uint64  `json:"block_number"`
+	Account     Account `json:"account"`
+}
+
+// QueryAtBlock retrieves an account as it was after the specified block
+// was applied.
+func (db *Database) QueryAtBlock(accountID AccountID, num uint64) (Account, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if num > db.latestBlock.Header.Number {
+		return Account{}, fmt.Errorf("block %d not in chain: %w", num, ErrNotFound)
+	}
+	if num > db.indexHeight {
+		return Account{}, fmt.Errorf("history for block %d not available yet", num)
+	}
+
+	buckets, err := db.buckets(nil, historyBucketsKey(accountID))
+	if err != nil {
+		return Account{}, err
+	}
+
+	for i := len(buckets) - 1; i >= 0; i-- {
+		if buckets[i] > num/bucketSize {
+			continue
+		}
+
+		var entries []historyEntry
+		if err := db.getIndex(nil, historyKey(accountID, buckets[i]), &entries); err != nil {
+			return Account{}, err
+		}
+
+		for j := len(entries) - 1; j >= 0; j-- {
+			if entries[j].BlockNumber <= num {
+				return entries[j].Account, nil
+			}
+		}
+	}
+
+	// The account hasn't changed since genesis.
+	if balance, exists := db.genesis.Balances[string(accountID)]; exists {
+		return newAccount(accountID, balance), nil
+	}
+
+	return Account{}, ErrAccountNotFound
+}
+
+// =============================================================================
+
+// indexHistory records the value of the accounts changed by the block into
+// the batch. The caller must hold the write lock.
+func (db *Database) indexHistory(batch MetaBatch, block Block) error {
+	num := block.Header.Number
+
+	for accountID := range db.undo[num] {
+		account, exists := db.accounts[accountID]
+		if !exists {
+			continue
+		}
+
+		bucket := num / bucketSize
+		entries, err := db.historyEntries(batch, accountID, bucket)
+		if err != nil {
+			return err
+		}
+
+		// The block could be indexed again after a crash.
+		i := sort.Search(len(entries), func(i int) bool { return entries[i].BlockNumber >= num })
+		entry := historyEntry{BlockNumber: num, Account: account}
+		switch {
+		case i < len(entries) && entries[i].BlockNumber == num:
+			entries[i] = entry
+		default:
+			entries = append(entries[:i], append([]historyEntry{entry}, entries[i:]...)...)
+		}
+
+		if err := db.putIndex(batch, historyKey(accountID, bucket), entries); err != nil {
+			return err
+		}
+
+		if err := db.updateBuckets(batch, historyBucketsKey(accountID), bucket, true); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// unindexHistory removes the values recorded for the accounts changed by
+// the block in the batch. The caller must hold the write lock.
+func (db *Database) unindexHistory(batch MetaBatch, block Block) error {
+	num := block.Header.Number
+	bucket := num / bucketSize
+
+	for accountID := range db.undo[num] {
+		entries, err := db.historyEntries(batch, accountID, bucket)
+		if err != nil {
+			return err
+		}
+
+		kept := entries[:0]
+		for _, entry := range entries {
+			if entry.BlockNumber != num {
+				kept = append(kept, entry)
+			}
+		}
+
+		if len(kept) > 0 {
+			if err := db.putIndex(batch, historyKey(accountID, bucket), kept); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := db.deleteIndex(batch, historyKey(accountID, bucket)); err != nil {
+			return err
+		}
+		if err := db.updateBuckets(batch, historyBucketsKey(accountID), bucket, false); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// historyEntries reads the history of the account for the specified bucket,
+// including the changes in the batch.
+func (db *Database) historyEntries(batch MetaBatch, accountID AccountID, bucket uint64) ([]historyEntry, error) {
+	var entries []historyEntry
+	if err := db.getIndex(batch, historyKey(accountID, bucket), &entries); err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// historyBucketsKey forms the key for the set of buckets holding history for
+// the account.
+func historyBucketsKey(accountID AccountID) string {
+	return historyPrefix + string(accountID)
+}
+
+// historyKey forms the key for the history of the account in the bucket.
+func historyKey(accountID AccountID, bucket uint64) string {
+	return historyPrefix + string(accountID) + "/" + strconv.FormatUint(bucket, 10)
+}
@@ -0,0 +1,275 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+)
+
+// CORE NOTE: The index lets blocks and transactions be located by hash
+// without walking the chain. It's kept in the storage meta data so it
+// survives a restart. The changes for a block are collected into a batch
+// along with the new height of the index and written together, so the
+// storage can write them at once. The batch is only seen by the writer, so
+// lookups read what is in storage. Any block after the height is indexed
+// again on startup.
+// Entries left behind by a crash or a lost block are harmless since every
+// lookup is checked against the block it points to. When the content of the
+// index changes, the version is bumped and every block is indexed again.
+
+// indexVersion represents the version of the content of the index.
+const indexVersion = 3
+
+// bucketSize represents the number of blocks grouped into a bucket for the
+// parts of the index kept per account.
+const bucketSize = 100
+
+// Keys used to store the index in the storage meta data.
+const (
+	indexHeightKey  = "index/height"
+	indexVersionKey = "index/version"
+	blockHashPrefix = "block/"
+	txHashPrefix    = "tx/"
+)
+
+// ErrNotFound is returned when a block or transaction isn't in the index.
+var ErrNotFound = errors.New("not found")
+
+// TxLocation represents where a transaction lives in the chain.
+type TxLocation struct {
+	BlockNumber uint64 `json:"block_number"` // Number of the block holding the transaction.
+	Index       int    `json:"index"`        // Position of the transaction in the block.
+}
+
+// BlockNumberByHash returns the number of the main chain block with the
+// specified hash.
+func (db *Database) BlockNumberByHash(hash string) (uint64, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var num uint64
+	if err := db.getIndex(nil, blockHashPrefix+hash, &num); err != nil {
+		return 0, err
+	}
+
+	block, err := db.GetBlock(num)
+	if err != nil || block.Hash() != hash {
+		return 0, ErrNotFound
+	}
+
+	return num, nil
+}
+
+// TxByHash returns the transaction with the specified hash from the main
+// chain along with its location.
+func (db *Database) TxByHash(hash string) (BlockTx, TxLocation, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var loc TxLocation
+	if err := db.getIndex(nil, txHashPrefix+hash, &loc); err != nil {
+		return BlockTx{}, TxLocation{}, err
+	}
+
+	block, err := db.GetBlock(loc.BlockNumber)
+	if err != nil {
+		return BlockTx{}, TxLocation{}, ErrNotFound
+	}
+
+	trans := block.MerkleTree.Values()
+	if loc.Index >= len(trans) || trans[loc.Index].TxHash() != hash {
+		return BlockTx{}, TxLocation{}, ErrNotFound
+	}
+
+	return trans[loc.Index], loc, nil
+}
+
+// =============================================================================
+
+// loadIndexHeight reads the number of the last block that was indexed. If
+// the index was built by a different version, every block is indexed again.
+func (db *Database) loadIndexHeight() error {
+	var version int
+	if err := db.getIndex(nil, indexVersionKey, &version); err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+
+	if version != indexVersion {
+		if err := db.setIndexHeight(0); err != nil {
+			return err
+		}
+		return db.putIndex(nil, indexVersionKey, indexVersion)
+	}
+
+	err := db.getIndex(nil, indexHeightKey, &db.indexHeight)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+
+	return err
+}
+
+// indexBlock adds the block and its transactions to the index. The block
+// must already be applied to the accounts. The caller must hold the write lock.
+func (db *Database) indexBlock(block Block) error {
+	num := block.Header.Number
+
+	return db.writeBatch(num, func(batch MetaBatch) error {
+		if err := db.putIndex(batch, blockHashPrefix+block.Hash(), num); err != nil {
+			return err
+		}
+
+		for i, tx := range block.MerkleTree.Values() {
+			loc := TxLocation{BlockNumber: num, Index: i}
+			if err := db.putIndex(batch, txHashPrefix+tx.TxHash(), loc); err != nil {
+				return err
+			}
+		}
+
+		if err := db.indexHistory(batch, block); err != nil {
+			return err
+		}
+
+		return db.indexAccountTxs(batch, block)
+	})
+}
+
+// unindexBlock removes the block and its transactions from the index. The
+// caller must hold the write lock.
+func (db *Database) unindexBlock(block Block) error {
+	return db.writeBatch(block.Header.Number-1, func(batch MetaBatch) error {
+		if err := db.deleteIndex(batch, blockHashPrefix+block.Hash()); err != nil {
+			return err
+		}
+
+		for _, tx := range block.MerkleTree.Values() {
+			if err := db.deleteIndex(batch, txHashPrefix+tx.TxHash()); err != nil {
+				return err
+			}
+		}
+
+		if err := db.unindexHistory(batch, block); err != nil {
+			return err
+		}
+
+		return db.unindexAccountTxs(batch, block)
+	})
+}
+
+// writeBatch collects the changes made to the index by the function into a
+// batch and writes them to storage along with the specified index height.
+// The caller must hold the write lock.
+func (db *Database) writeBatch(height uint64, fn func(batch MetaBatch) error) error {
+	batch := make(MetaBatch)
+	if err := fn(batch); err != nil {
+		return err
+	}
+
+	batch[indexHeightKey] = []byte(strconv.FormatUint(height, 10))
+	if err := db.storage.WriteMeta(batch); err != nil {
+		return err
+	}
+	db.indexHeight = height
+
+	return nil
+}
+
+// setIndexHeight records the number of the last block that was indexed.
+func (db *Database) setIndexHeight(num uint64) error {
+	if err := db.storage.PutMeta(indexHeightKey, []byte(strconv.FormatUint(num, 10))); err != nil {
+		return err
+	}
+	db.indexHeight = num
+
+	return nil
+}
+
+// putIndex stores the value for the specified key as JSON. If a batch is
+// provided, the value is added to the batch instead.
+func (db *Database) putIndex(batch MetaBatch, key string, value any) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	if batch != nil {
+		batch[key] = data
+		return nil
+	}
+
+	return db.storage.PutMeta(key, data)
+}
+
+// deleteIndex removes the specified key. If a batch is provided, the delete
+// is added to the batch instead.
+func (db *Database) deleteIndex(batch MetaBatch, key string) error {
+	if batch != nil {
+		batch[key] = nil
+		return nil
+	}
+
+	return db.storage.DeleteMeta(key)
+}
+
+// getIndex decodes the value for the specified key. ErrNotFound is returned
+// if the key doesn't exist. If a batch is provided, its changes are seen
+// before they are written.
+func (db *Database) getIndex(batch MetaBatch, key string, value any) error {
+	data, inBatch := batch[key]
+	if inBatch && data == nil {
+		return ErrNotFound
+	}
+
+	if !inBatch {
+		var err error
+		data, err = db.storage.GetMeta(key)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return ErrNotFound
+			}
+			return err
+		}
+	}
+
+	if err := json.Unmarshal(data, value); err != nil {
+		return fmt.Errorf("decoding index %s: %w", key, err)
+	}
+
+	return nil
+}
+
+// buckets reads the set of buckets stored under the specified key.
+func (db *Database) buckets(batch MetaBatch, key string) ([]uint64, error) {
+	var buckets []uint64
+	if err := db.getIndex(batch, key, &buckets); err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+
+	return buckets, nil
+}
+
+// updateBuckets adds or removes the bucket from the set of buckets stored
+// under the specified key in the batch.
+func (db *Database) updateBuckets(batch MetaBatch, key string, bucket uint64, add bool) error {
+	buckets, err := db.buckets(batch, key)
+	if err != nil {
+		return err
+	}
+
+	i := sort.Search(len(buckets), func(i int) bool { return buckets[i] >= bucket })
+	exists := i < len(buckets) && buckets[i] == bucket
+
+	switch {
+	case add && !exists:
+		buckets = append(buckets[:i], append([]uint64{bucket}, buckets[i:]...)...)
+	case !add && exists:
+		buckets = append(buckets[:i], buckets[i+1:]...)
+	default:
+		return nil
+	}
+
+	return db.putIndex(batch, key, buckets)
+}
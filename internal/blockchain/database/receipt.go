@@ -0,0 +1,64 @@
+package database
+
+// CORE NOTE: A transaction that makes it into a block isn't guaranteed to
+// succeed. The nonce or the balance can be wrong by the time the block is
+// applied, yet the gas fee is still taken. A receipt records the outcome of
+// each transaction so the user can find out what happened. Receipts are
+// produced by applying the block locally and are stored with the block, but
+// they are not part of the block hash.
+
+// Set of statuses a receipt can have.
+const (
+	ReceiptSuccess = "success"
+	ReceiptFailed  = "failed"
+)
+
+// Receipt represents the outcome of applying a transaction in a block.
+type Receipt struct {
+	TxHash      string `json:"tx_hash"`         // Hash of the transaction.
+	BlockNumber uint64 `json:"block_number"`    // Number of the block holding the transaction.
+	Index       int    `json:"index"`           // Position of the transaction in the block.
+	Status      string `json:"status"`          // Whether the transaction was applied.
+	GasCharged  uint64 `json:"gas_charged"`     // Gas fee taken from the sender.
+	Error       string `json:"error,omitempty"` // Reason the transaction failed.
+}
+
+// NewReceipt constructs a receipt for the transaction at the specified index
+// in the block based on the result of applying it.
+func NewReceipt(block Block, index int, tx BlockTx, gasCharged uint64, err error) Receipt {
+	receipt := Receipt{
+		TxHash:      tx.TxHash(),
+		BlockNumber: block.Header.Number,
+		Index:       index,
+		Status:      ReceiptSuccess,
+		GasCharged:  gasCharged,
+	}
+
+	if err != nil {
+		receipt.Status = ReceiptFailed
+		receipt.Error = err.Error()
+	}
+
+	return receipt
+}
+
+// ReceiptByHash returns the receipt for the transaction with the specified
+// hash from the main chain.
+func (db *Database) ReceiptByHash(hash string) (Receipt, error) {
+	_, loc, err := db.TxByHash(hash)
+	if err != nil {
+		return Receipt{}, err
+	}
+
+	block, err := db.GetBlock(loc.BlockNumber)
+	if err != nil {
+		return Receipt{}, err
+	}
+
+	// Blocks written before receipts were introduced don't have any.
+	if loc.Index >= len(block.Receipts) {
+		return Receipt{}, ErrNotFound
+	}
+
+	return block.Receipts[loc.Index], nil
+}
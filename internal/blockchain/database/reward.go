@@ -0,0 +1,152 @@
+package database
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+// CORE NOTE: The monetary policy is declared in the genesis file. Like
+// Bitcoin, the mining reward is cut in half every halving interval, and no
+// more coins are minted once the maximum supply is reached. The reward for a
+// block only depends on its number, so every node computes the same value
+// without looking at the accounts. A percentage of the gas fees can be burned
+// instead of paid to the beneficiary, taking those coins out of circulation.
+// Since every coin is held by an account, the circulating supply is the sum
+// of the balances.
+
+// Supply represents the coins in existence as of the latest block.
+type Supply struct {
+	BlockNumber uint64 `json:"block_number"`
+	Genesis     uint64 `json:"genesis"`     // Coins given to the accounts in the genesis file.
+	Minted      uint64 `json:"minted"`      // Coins paid as mining rewards.
+	Burned      uint64 `json:"burned"`      // Coins burned from gas fees.
+	Circulating uint64 `json:"circulating"` // Coins held by the accounts.
+	MaxSupply   uint64 `json:"max_supply"`  // Zero when the supply is not capped.
+	NextReward  uint64 `json:"next_reward"` // Reward for mining the next block.
+}
+
+// MiningReward returns the reward for mining the block with the specified
+// number under the schedule declared in the genesis file.
+func (db *Database) MiningReward(number uint64) uint64 {
+	if number == 0 {
+		return 0
+	}
+
+	return db.minted(number) - db.minted(number-1)
+}
+
+// Supply returns the coins in existence as of the latest block.
+func (db *Database) Supply() Supply {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var circulating uint64
+	for _, account := range db.accounts {
+		circulating += account.Balance
+	}
+
+	number := db.latestBlock.Header.Number
+	genesis := db.genesisSupply()
+	minted := db.minted(number)
+
+	var burned uint64
+	if genesis+minted > circulating {
+		burned = genesis + minted - circulating
+	}
+
+	supply := Supply{
+		BlockNumber: number,
+		Genesis:     genesis,
+		Minted:      minted,
+		Burned:      burned,
+		Circulating: circulating,
+		MaxSupply:   db.genesis.MaxSupply,
+		NextReward:  db.MiningReward(number + 1),
+	}
+
+	return supply
+}
+
+// =============================================================================
+
+// validateMiningReward validates the block pays the reward the schedule
+// declares for its number.
+func (db *Database) validateMiningReward(block Block) error {
+	if reward := db.MiningReward(block.Header.Number); block.Header.MiningReward != reward {
+		return fmt.Errorf("%w: got %d, exp %d", ErrInvalidMiningReward, block.Header.MiningReward, reward)
+	}
+
+	return nil
+}
+
+// minted returns the coins paid as mining rewards for the blocks up to and
+// including the block with the specified number.
+func (db *Database) minted(number uint64) uint64 {
+	var minted uint64
+
+	reward := db.genesis.MiningReward
+	interval := db.genesis.HalvingInterval
+	for blocks := number; blocks > 0 && reward > 0; reward /= 2 {
+		// Without a halving interval the reward never changes.
+		era := blocks
+		if interval > 0 {
+			era = min(blocks, interval)
+		}
+		blocks -= era
+
+		minted = addCapped(minted, mulCapped(reward, era))
+	}
+
+	if db.genesis.MaxSupply == 0 {
+		return minted
+	}
+
+	// Only the room left under the maximum supply can be minted.
+	var room uint64
+	if genesis := db.genesisSupply(); db.genesis.MaxSupply > genesis {
+		room = db.genesis.MaxSupply - genesis
+	}
+
+	return min(minted, room)
+}
+
+// genesisSupply returns the coins given to the accounts in the genesis file.
+func (db *Database) genesisSupply() uint64 {
+	var supply uint64
+	for _, balance := range db.genesis.Balances {
+		supply = addCapped(supply, balance)
+	}
+
+	return supply
+}
+
+// burnedFee returns the part of the gas fee that is burned.
+func (db *Database) burnedFee(gasFee uint64) uint64 {
+	hi, lo := bits.Mul64(gasFee, db.genesis.FeeBurnPercent)
+	burned, _ := bits.Div64(hi, lo, 100)
+
+	return burned
+}
+
+// addCapped adds the values, stopping at the maximum value instead of
+// wrapping around.
+func addCapped(a uint64, b uint64) uint64 {
+	sum, carry := bits.Add64(a, b, 0)
+	if carry != 0 {
+		return math.MaxUint64
+	}
+
+	return sum
+}
+
+// mulCapped multiplies the values, stopping at the maximum value instead of
+// wrapping around.
+func mulCapped(a uint64, b uint64) uint64 {
+	hi, lo := bits.Mul64(a, b)
+	if hi != 0 {
+		return math.MaxUint64
+	}
+
+	return lo
+}
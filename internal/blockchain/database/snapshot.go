@@ -0,0 +1,215 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+
+	"github.com/opplieam/bund-blockchain/internal/blockchain/events"
+	"github.com/opplieam/bund-blockchain/internal/utils/signature"
+)
+
+// CORE NOTE: Without snapshots, the accounts are rebuilt on startup by
+// validating and replaying every block from block 1. A snapshot captures the
+// accounts after a block along with the state hash and cumulative work at that
+// point, so only the blocks after the snapshot need to be replayed. A snapshot
+// is only used if the blocks after it cover the reorganization depth, which
+// lets the undo information be rebuilt by the replay. Replaying everything is
+// still possible for auditing the chain.
+
+// Keys used to store the snapshots in the storage meta data.
+const (
+	snapshotListKey = "snapshot/list"
+	snapshotPrefix  = "snapshot/"
+)
+
+// snapshot represents the state of the accounts after the specified block.
+type snapshot struct {
+	Number    uint64    `json:"number"`
+	BlockHash string    `json:"block_hash"`
+	HashState string    `json:"hash_state"`
+	TotalWork *big.Int  `json:"total_work"`
+	Accounts  []Account `json:"accounts"`
+}
+
+// WithSnapshotInterval sets the number of blocks between account snapshots.
+// An interval of 0 turns snapshots off.
+func WithSnapshotInterval(interval uint64) func(db *Database) {
+	return func(db *Database) {
+		db.snapshotInterval = interval
+	}
+}
+
+// WithFullReplay ignores any snapshots on startup and replays every block in
+// the chain.
+func WithFullReplay() func(db *Database) {
+	return func(db *Database) {
+		db.fullReplay = true
+	}
+}
+
+// WriteSnapshot persists a snapshot of the accounts if the latest block
+// falls on the snapshot interval. Snapshots that are no longer needed to
+// cover the reorganization depth are removed.
+func (db *Database) WriteSnapshot() error {
+	db.mu.RLock()
+	num := db.latestBlock.Header.Number
+	db.mu.RUnlock()
+
+	if db.snapshotInterval == 0 || num == 0 || num%db.snapshotInterval != 0 {
+		return nil
+	}
+
+	// The snapshot could have been written before a restart.
+	for _, n := range db.snapshots {
+		if n == num {
+			return nil
+		}
+	}
+
+	db.mu.RLock()
+	snap := snapshot{
+		Number:    num,
+		BlockHash: db.latestBlock.Hash(),
+		TotalWork: new(big.Int).Set(db.totalWork),
+		Accounts:  make([]Account, 0, len(db.accounts)),
+	}
+	for _, account := range db.accounts {
+		snap.Accounts = append(snap.Accounts, account)
+	}
+	db.mu.RUnlock()
+
+	sort.Sort(byAccount(snap.Accounts))
+	snap.HashState = signature.Hash(snap.Accounts)
+
+	if err := db.putIndex(nil, snapshotKey(num), snap); err != nil {
+		return err
+	}
+
+	// Keep the newest snapshot that is deep enough to be used and all the
+	// snapshots after it.
+	snapshots := []uint64{num}
+	for i := len(db.snapshots) - 1; i >= 0; i-- {
+		if db.snapshots[i] >= num {
+			continue
+		}
+		if snapshots[len(snapshots)-1]+MaxReorgDepth <= num {
+			if err := db.storage.DeleteMeta(snapshotKey(db.snapshots[i])); err != nil {
+				return err
+			}
+			continue
+		}
+		snapshots = append(snapshots, db.snapshots[i])
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i] < snapshots[j] })
+
+	return db.setSnapshots(snapshots)
+}
+
+// =============================================================================
+
+// loadSnapshot restores the accounts from the newest usable snapshot. The
+// number of the snapshot block is returned, or 0 if no snapshot was used.
+func (db *Database) loadSnapshot(evHandler events.Handler) (uint64, error) {
+	err := db.getIndex(nil, snapshotListKey, &db.snapshots)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return 0, err
+	}
+
+	if db.fullReplay {
+		return 0, nil
+	}
+
+	for i := len(db.snapshots) - 1; i >= 0; i-- {
+		num := db.snapshots[i]
+
+		// The blocks after the snapshot need to be indexed and cover the
+		// reorganization depth.
+		if num+MaxReorgDepth > db.indexHeight {
+			continue
+		}
+
+		snap, block, err := db.readSnapshot(num)
+		if err != nil {
+			evHandler(events.Event{Kind: events.KindWarning, Subsystem: "database", Op: "loadSnapshot", Msg: "snapshot not usable", BlockNumber: num, Err: err})
+			continue
+		}
+
+		db.accounts = make(map[AccountID]Account)
+		for _, account := range snap.Accounts {
+			db.accounts[account.AccountID] = account
+		}
+		db.latestBlock = block
+		db.totalWork = snap.TotalWork
+
+		evHandler(events.Event{Subsystem: "database", Op: "loadSnapshot", Msg: "loaded", BlockNumber: num})
+		return num, nil
+	}
+
+	return 0, nil
+}
+
+// readSnapshot reads the snapshot for the specified block and checks it
+// against the chain.
+func (db *Database) readSnapshot(num uint64) (snapshot, Block, error) {
+	var snap snapshot
+	if err := db.getIndex(nil, snapshotKey(num), &snap); err != nil {
+		return snapshot{}, Block{}, err
+	}
+
+	block, err := db.GetBlock(num)
+	if err != nil {
+		return snapshot{}, Block{}, err
+	}
+
+	if block.Hash() != snap.BlockHash {
+		return snapshot{}, Block{}, fmt.Errorf("block hash doesn't match, got %s, exp %s", block.Hash(), snap.BlockHash)
+	}
+
+	sort.Sort(byAccount(snap.Accounts))
+	if hash := signature.Hash(snap.Accounts); hash != snap.HashState {
+		return snapshot{}, Block{}, fmt.Errorf("state hash doesn't match, got %s, exp %s", hash, snap.HashState)
+	}
+
+	if snap.TotalWork == nil {
+		return snapshot{}, Block{}, errors.New("missing total work")
+	}
+
+	return snap, block, nil
+}
+
+// dropSnapshot removes the snapshot for the specified block when the block
+// is rolled back. The caller must hold the write lock.
+func (db *Database) dropSnapshot(num uint64) error {
+	for i, n := range db.snapshots {
+		if n != num {
+			continue
+		}
+
+		if err := db.storage.DeleteMeta(snapshotKey(num)); err != nil {
+			return err
+		}
+
+		snapshots := append(append([]uint64{}, db.snapshots[:i]...), db.snapshots[i+1:]...)
+		return db.setSnapshots(snapshots)
+	}
+
+	return nil
+}
+
+// setSnapshots records the numbers of the blocks that have a snapshot.
+func (db *Database) setSnapshots(snapshots []uint64) error {
+	if err := db.putIndex(nil, snapshotListKey, snapshots); err != nil {
+		return err
+	}
+	db.snapshots = snapshots
+
+	return nil
+}
+
+// snapshotKey forms the key for the snapshot of the specified block.
+func snapshotKey(num uint64) string {
+	return snapshotPrefix + strconv.FormatUint(num, 10)
+}
@@ -0,0 +1,84 @@
+package database
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/opplieam/bund-blockchain/internal/blockchain/events"
+)
+
+// CORE NOTE: Block timestamps are in milliseconds and are set by the node
+// that produced the block, so they can't be trusted on their own. Like
+// Bitcoin, a block's timestamp must be later than the median timestamp of
+// the blocks before it, instead of later than its parent. Nodes with clocks
+// a little apart can still build on each other's blocks, but a single node
+// with a bad clock can't drag the chain's time backwards. A block can't be
+// too far ahead of our own clock either, otherwise a miner could push the
+// time forward to lower the difficulty or expire transactions early.
+
+// medianTimeBlocks is the number of blocks the median time past is computed
+// from.
+const medianTimeBlocks = 11
+
+// maxFutureDrift is how far ahead of the local clock a block's timestamp is
+// allowed to be.
+const maxFutureDrift = 15 * time.Second
+
+// MinTimeStamp returns the lowest timestamp in milliseconds a block that
+// builds on the specified parent block can have.
+func (db *Database) MinTimeStamp(parent Block) (uint64, error) {
+	median, err := db.medianTimePast(parent)
+	if err != nil {
+		return 0, err
+	}
+
+	return median + 1, nil
+}
+
+// =============================================================================
+
+// medianTimePast returns the median timestamp of the last blocks ending with
+// the specified block. The genesis block has no timestamp, so 0 is returned
+// before the first block.
+func (db *Database) medianTimePast(block Block) (uint64, error) {
+	var times []uint64
+	for len(times) < medianTimeBlocks && block.Header.Number > 0 {
+		times = append(times, block.Header.TimeStamp)
+
+		if block.Header.Number == 1 {
+			break
+		}
+
+		parent, err := db.findParent(block)
+		if err != nil {
+			return 0, err
+		}
+		block = parent
+	}
+
+	if len(times) == 0 {
+		return 0, nil
+	}
+
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+
+	return times[len(times)/2], nil
+}
+
+// validateTimestamp validates the block's timestamp is later than the median
+// time past of the blocks before it.
+func (db *Database) validateTimestamp(block Block, parent Block, evHandler events.Handler) error {
+	evHandler(events.Event{Subsystem: "database", Op: "ValidateBlock", Msg: "check: block's timestamp is after the median time past", BlockNumber: block.Header.Number})
+
+	median, err := db.medianTimePast(parent)
+	if err != nil {
+		return err
+	}
+
+	if block.Header.TimeStamp <= median {
+		return fmt.Errorf("%w: block timestamp is not after the median time past, median %d, block %d", ErrInvalidTimestamp, median, block.Header.TimeStamp)
+	}
+
+	return nil
+}
@@ -21,6 +21,12 @@ type Tx struct {
 	Value   uint64    `json:"value"`    // Ethereum: Monetary value received from this transaction.
 	Tip     uint64    `json:"tip"`      // Ethereum: Tip offered by the sender as an incentive to mine this transaction.
 	Data    []byte    `json:"data"`     // Ethereum: Extra data related to the transaction.
+
+	// The transaction can't be mined after these if they are set. The fields
+	// are left out of the JSON when they are zero so transactions signed
+	// before they existed keep the same signature.
+	ExpiryBlock uint64 `json:"expiry_block,omitempty"` // Last block number the transaction can be mined in.
+	ExpiryTime  uint64 `json:"expiry_time,omitempty"`  // Last block timestamp in Unix milliseconds the transaction can be mined at.
 }
 
 // NewTx constructs a new transaction.
@@ -38,6 +44,16 @@ func NewTx(chainID uint16, nonce uint64, fromID AccountID, toID AccountID, value
 	return tx, nil
 }
 
+// Expired checks if the transaction can no longer be mined in a block with
+// the specified number and timestamp in Unix milliseconds.
+func (tx Tx) Expired(blockNumber uint64, timeStamp uint64) bool {
+	if tx.ExpiryBlock != 0 && blockNumber > tx.ExpiryBlock {
+		return true
+	}
+
+	return tx.ExpiryTime != 0 && timeStamp > tx.ExpiryTime
+}
+
 // SignedTx is a signed version of the transaction. This is how clients like
 // a wallet provide transactions for inclusion into the blockchain.
 type SignedTx struct {
@@ -104,6 +120,12 @@ func (tx SignedTx) SignatureString() string {
 	return signature.ToSignatureString(tx.V, tx.R, tx.S)
 }
 
+// TxHash returns the unique hash for the signed transaction. This is the id
+// used to look up the transaction once it's been mined into a block.
+func (tx SignedTx) TxHash() string {
+	return signature.Hash(tx)
+}
+
 // String implements the Stringer interface for logging.
 func (tx SignedTx) String() string {
 	return fmt.Sprintf("%s:%d", tx.FromID, tx.Nonce)
@@ -0,0 +1,66 @@
+package database
+
+// MaxReorgDepth represents the number of blocks from the tip of the chain
+// the database keeps undo information for. A fork that is deeper than this
+// can't be rolled back and requires the node to be reset.
+const MaxReorgDepth = 100
+
+// accountUndo captures the value of an account before a block modified it.
+// If the account didn't exist before the block, exists is false and the
+// account is removed when the block is rolled back.
+type accountUndo struct {
+	account Account
+	exists  bool
+}
+
+// undoLog represents the set of changes required to roll back a block.
+type undoLog map[AccountID]accountUndo
+
+// recordUndo captures the current value of the specified accounts for the
+// block being applied. Only the first value seen for an account is kept since
+// that is the value prior to the block. The caller must hold the write lock.
+func (db *Database) recordUndo(blockNum uint64, accountIDs ...AccountID) {
+	log, exists := db.undo[blockNum]
+	if !exists {
+		log = make(undoLog)
+		db.undo[blockNum] = log
+
+		// Don't keep undo information deeper than we are willing to reorganize.
+		if blockNum > MaxReorgDepth {
+			delete(db.undo, blockNum-MaxReorgDepth)
+		}
+	}
+
+	for _, accountID := range accountIDs {
+		if _, recorded := log[accountID]; recorded {
+			continue
+		}
+
+		account, exists := db.accounts[accountID]
+		log[accountID] = accountUndo{account: account, exists: exists}
+	}
+}
+
+// DiscardBlock restores the accounts changed while applying the specified
+// block when the block could not be added to the chain.
+func (db *Database) DiscardBlock(blockNum uint64) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.restoreAccounts(blockNum)
+}
+
+// restoreAccounts restores the accounts to the value they held before the
+// specified block was applied and removes the undo information for the block.
+// The caller must hold the write lock.
+func (db *Database) restoreAccounts(blockNum uint64) {
+	for accountID, u := range db.undo[blockNum] {
+		if !u.exists {
+			delete(db.accounts, accountID)
+			continue
+		}
+		db.accounts[accountID] = u.account
+	}
+
+	delete(db.undo, blockNum)
+}
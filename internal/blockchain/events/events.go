@@ -0,0 +1,109 @@
+// Package events provides support for publishing what happens in the
+// processing of the blockchain to any number of consumers.
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// CORE NOTE: Every subsystem reports what it's doing by publishing an Event
+// on the bus. Consumers like logging, metrics and the WebSocket feed register
+// a handler with the bus and look at the fields they care about instead of
+// parsing strings. Handlers are called synchronously by the goroutine doing
+// the publishing, so they must not block.
+
+// Kind identifies what an event is reporting.
+type Kind string
+
+// Set of event kinds. Kinds other than trace, warning and error report a
+// change to the chain that consumers may want to act on. For those, the
+// Data field is documented with the kind.
+const (
+	KindTrace           Kind = "trace"            // Progress of an operation.
+	KindWarning         Kind = "warning"          // Something failed, but processing continued.
+	KindError           Kind = "error"            // An operation failed.
+	KindBlockMined      Kind = "block_mined"      // This node solved a block. Duration is the mining time.
+	KindBlockAdded      Kind = "block_added"      // A block was added to the chain. Data is the database.Block.
+	KindBlockRejected   Kind = "block_rejected"   // A proposed block failed validation. Err is the reason.
+	KindSideBlock       Kind = "side_block"       // A block was kept on a side branch.
+	KindReorg           Kind = "reorg"            // The chain switched branch. Data is the orphaned []database.Block.
+	KindTxAdded         Kind = "tx_added"         // A transaction was added to the mempool. Data is the database.BlockTx.
+	KindTxDropped       Kind = "tx_dropped"       // A transaction expired in the mempool. Data is the database.BlockTx.
+	KindMiningCancelled Kind = "mining_cancelled" // A mining operation was cancelled.
+	KindPeerAdded       Kind = "peer_added"       // A new peer was discovered.
+	KindPeerRequest     Kind = "peer_request"     // A request to a peer finished. Duration is the latency.
+	KindPowCompleted    Kind = "pow_completed"    // A search for a POW solution ended. Data is the uint64 number of hashes tried.
+	KindStorageWrite    Kind = "storage_write"    // A block was written to storage. Duration is the latency.
+)
+
+// Event represents something that happened in a subsystem. Only the fields
+// that apply to the event are set.
+type Event struct {
+	Time        time.Time
+	Kind        Kind
+	Subsystem   string        // The package reporting the event, like state or worker.
+	Op          string        // The operation being performed, like MineNewBlock.
+	Msg         string        // Human readable description of the event.
+	BlockNumber uint64        // The block the event is about.
+	TxKey       string        // The transaction the event is about, as from:nonce.
+	Peer        string        // The host of the peer the event is about.
+	Err         error         // The failure for warning and error events.
+	Duration    time.Duration // How long the operation took.
+	Data        any           // Value the kind of event carries.
+}
+
+// Handler defines a function that is called when an event is published.
+type Handler func(ev Event)
+
+// =============================================================================
+
+// Bus delivers published events to the registered handlers.
+type Bus struct {
+	mu       sync.RWMutex
+	handlers map[int]Handler
+	nextID   int
+}
+
+// NewBus constructs a bus for use.
+func NewBus() *Bus {
+	return &Bus{
+		handlers: make(map[int]Handler),
+	}
+}
+
+// Subscribe registers the handler to receive every event published on the
+// bus. The function returned removes the handler.
+func (b *Bus) Subscribe(handler Handler) func() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	id := b.nextID
+	b.nextID++
+	b.handlers[id] = handler
+
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		delete(b.handlers, id)
+	}
+}
+
+// Publish delivers the event to every handler. An event without a kind is
+// a trace, and an event without a time is given the current time.
+func (b *Bus) Publish(ev Event) {
+	if ev.Kind == "" {
+		ev.Kind = KindTrace
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for _, handler := range b.handlers {
+		handler(ev)
+	}
+}
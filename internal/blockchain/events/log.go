@@ -0,0 +1,48 @@
+package events
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Log returns a handler that writes every event to the logger. Warnings and
+// errors are logged at their own level, everything else at info.
+func Log(log *slog.Logger) Handler {
+	return func(ev Event) {
+		level := slog.LevelInfo
+		switch ev.Kind {
+		case KindWarning:
+			level = slog.LevelWarn
+		case KindError:
+			level = slog.LevelError
+		}
+
+		attrs := []slog.Attr{
+			slog.String("kind", string(ev.Kind)),
+			slog.String("subsystem", ev.Subsystem),
+			slog.String("op", ev.Op),
+		}
+		if ev.BlockNumber != 0 {
+			attrs = append(attrs, slog.Uint64("block", ev.BlockNumber))
+		}
+		if ev.TxKey != "" {
+			attrs = append(attrs, slog.String("tx", ev.TxKey))
+		}
+		if ev.Peer != "" {
+			attrs = append(attrs, slog.String("peer", ev.Peer))
+		}
+		if ev.Duration != 0 {
+			attrs = append(attrs, slog.Duration("duration", ev.Duration))
+		}
+		if ev.Err != nil {
+			attrs = append(attrs, slog.String("error", ev.Err.Error()))
+		}
+
+		msg := ev.Msg
+		if msg == "" {
+			msg = ev.Op
+		}
+
+		log.LogAttrs(context.Background(), level, msg, attrs...)
+	}
+}
@@ -8,19 +8,29 @@ import (
 
 // Genesis represents the genesis file.
 type Genesis struct {
-	Date          time.Time         `json:"date"`
-	ChainID       uint16            `json:"chain_id"`        // The chain id represents an unique id for this running instance.
-	TransPerBlock uint16            `json:"trans_per_block"` // The maximum number of transactions that can be in a block.
-	Difficulty    uint16            `json:"difficulty"`      // How difficult it needs to be to solve the work problem.
-	MiningReward  uint64            `json:"mining_reward"`   // Reward for mining a block.
-	GasPrice      uint64            `json:"gas_price"`       // Fee paid for each transaction mined into a block.
-	Balances      map[string]uint64 `json:"balances"`
+	Date             time.Time         `json:"date"`
+	ChainID          uint16            `json:"chain_id"`                    // The chain id represents an unique id for this running instance.
+	TransPerBlock    uint16            `json:"trans_per_block"`             // The maximum number of transactions that can be in a block.
+	Difficulty       uint16            `json:"difficulty"`                  // How difficult it needs to be to solve the work problem.
+	TargetBlockTime  uint64            `json:"target_block_time,omitempty"` // Seconds the network aims to take for each block.
+	RetargetInterval uint64            `json:"retarget_interval,omitempty"` // Number of blocks between difficulty adjustments.
+	MiningReward     uint64            `json:"mining_reward"`               // Reward for mining a block.
+	HalvingInterval  uint64            `json:"halving_interval,omitempty"`  // Number of blocks between halvings of the mining reward.
+	MaxSupply        uint64            `json:"max_supply,omitempty"`        // Maximum number of coins that can ever exist.
+	GasPrice         uint64            `json:"gas_price"`                   // Fee paid for each transaction mined into a block.
+	FeeBurnPercent   uint64            `json:"fee_burn_percent,omitempty"`  // Percentage of the gas fee burned instead of paid to the beneficiary.
+	Balances         map[string]uint64 `json:"balances"`
+	Validators       []string          `json:"validators,omitempty"` // Accounts that take turns producing blocks under POA.
 }
 
 // Load opens and consumes the genesis file.
 func Load() (Genesis, error) {
 	// TODO: Change it to env or params
-	path := "conf/genesis.json"
+	return LoadFile("conf/genesis.json")
+}
+
+// LoadFile opens and consumes the genesis file at the specified path.
+func LoadFile(path string) (Genesis, error) {
 	content, err := os.ReadFile(path)
 	if err != nil {
 		return Genesis{}, err
@@ -0,0 +1,165 @@
+package mempool
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/opplieam/bund-blockchain/internal/blockchain/database"
+)
+
+// CORE NOTE: The journal is a file with one JSON encoded transaction per line.
+// Accepted transactions are appended, so a restart can put them back into the
+// mempool. Transactions that leave the mempool are not removed from the file,
+// instead the file is compacted by rewriting it with what is in the mempool.
+// A crash while appending can leave a partial last line, which is skipped when
+// the journal is loaded.
+
+// Journal records the transactions accepted into the mempool on disk.
+type Journal struct {
+	mu      sync.Mutex
+	path    string
+	file    *os.File
+	entries int
+}
+
+// OpenJournal opens the journal at the specified path, creating it if it
+// doesn't exist.
+func OpenJournal(path string) (*Journal, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Journal{path: path, file: f}, nil
+}
+
+// Load reads the transactions recorded in the journal in the order they were
+// appended. Lines that can't be decoded are skipped.
+func (j *Journal) Load() ([]database.BlockTx, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	f, err := os.Open(j.path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var txs []database.BlockTx
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var tx database.BlockTx
+		if err := json.Unmarshal(scanner.Bytes(), &tx); err != nil {
+			continue
+		}
+		txs = append(txs, tx)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	j.entries = len(txs)
+
+	return txs, nil
+}
+
+// Append records the transaction at the end of the journal.
+func (j *Journal) Append(tx database.BlockTx) error {
+	data, err := json.Marshal(tx)
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if _, err := j.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	j.entries++
+
+	return nil
+}
+
+// Entries returns the number of transactions recorded in the journal.
+func (j *Journal) Entries() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.entries
+}
+
+// Compact rewrites the journal with the transactions returned by the
+// function. The function is called while appends are blocked, so nothing
+// accepted into the mempool in the meantime is lost. The new journal is
+// written to a temporary file that is renamed over the old one, so a crash
+// leaves one or the other.
+func (j *Journal) Compact(txsFn func() []database.BlockTx) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	txs := txsFn()
+
+	tmpPath := j.path + ".tmp"
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, tx := range txs {
+		if err := enc.Encode(tx); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpPath, j.path); err != nil {
+		return err
+	}
+
+	// Appends have to go to the new file.
+	nf, err := os.OpenFile(j.path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	j.file.Close()
+	j.file = nf
+	j.entries = len(txs)
+
+	return nil
+}
+
+// Close closes the journal file.
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.file.Close()
+}
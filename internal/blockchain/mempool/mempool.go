@@ -4,18 +4,117 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/opplieam/bund-blockchain/internal/blockchain/database"
 	"github.com/opplieam/bund-blockchain/internal/blockchain/mempool/selector"
 )
 
+// Set of errors returned when a transaction is rejected for capacity.
+var (
+	ErrMempoolFull  = errors.New("mempool is full")
+	ErrAccountLimit = errors.New("account has too many transactions in the mempool")
+)
+
+// ErrNonceTooLow is returned when stale nonces are rejected and a transaction
+// uses a nonce the account has already used in a mined transaction.
+var ErrNonceTooLow = errors.New("nonce too low")
+
+// ErrUnderpriced is returned when a transaction replacing another doesn't
+// bump the tip enough.
+var ErrUnderpriced = errors.New("replacing a transaction requires a 10% bump in the tip")
+
+// Set of eviction policies for making room in a full mempool.
+const (
+	EvictLowestTip = "tip"
+	EvictOldest    = "oldest"
+)
+
+// txOverhead is the estimated number of bytes a transaction takes up in the
+// pool, not including its data.
+const txOverhead = 512
+
+// NonceFunc defines a function that returns the nonce of the last mined
+// transaction for the account.
+type NonceFunc func(accountID database.AccountID) uint64
+
 // Mempool represents a cache of transactions organized by account:nonce.
 type Mempool struct {
-	mu       sync.RWMutex
-	pool     map[string]database.BlockTx
-	selectFn selector.Func
+	mu         sync.RWMutex
+	pool       map[string]database.BlockTx
+	perAccount map[database.AccountID]int
+	pendingTo  map[database.AccountID]uint64
+	bytes      int
+	selectFn   selector.Func
+	nonceFn    NonceFunc
+	ttl        time.Duration
+
+	rejectStale bool
+
+	maxTxs        int
+	maxBytes      int
+	maxPerAccount int
+	eviction      string
+}
+
+// WithMaxTxs limits the number of transactions in the pool. A limit of 0
+// means no limit.
+func WithMaxTxs(max int) func(mp *Mempool) {
+	return func(mp *Mempool) {
+		mp.maxTxs = max
+	}
+}
+
+// WithMaxBytes limits the estimated memory used by the transactions in the
+// pool. A limit of 0 means no limit.
+func WithMaxBytes(max int) func(mp *Mempool) {
+	return func(mp *Mempool) {
+		mp.maxBytes = max
+	}
+}
+
+// WithMaxPerAccount limits the number of transactions a single account can
+// have in the pool. A limit of 0 means no limit.
+func WithMaxPerAccount(max int) func(mp *Mempool) {
+	return func(mp *Mempool) {
+		mp.maxPerAccount = max
+	}
+}
+
+// WithEviction sets the policy used to pick the transaction that is dropped
+// when the pool is full.
+func WithEviction(policy string) func(mp *Mempool) {
+	return func(mp *Mempool) {
+		mp.eviction = strings.ToLower(policy)
+	}
+}
+
+// WithNonceSource provides the nonces of the accounts so transactions can be
+// split into pending and queued. Without it, every transaction is pending.
+func WithNonceSource(nonceFn NonceFunc) func(mp *Mempool) {
+	return func(mp *Mempool) {
+		mp.nonceFn = nonceFn
+	}
+}
+
+// WithRejectStaleNonce rejects transactions for a nonce the account has
+// already used. Otherwise they are accepted and dropped once the pool is
+// brought in line with the account nonces. It requires a nonce source.
+func WithRejectStaleNonce(reject bool) func(mp *Mempool) {
+	return func(mp *Mempool) {
+		mp.rejectStale = reject
+	}
+}
+
+// WithTTL sets how long a transaction can wait in the pool before it's
+// dropped by a sweep. A TTL of 0 keeps transactions until they are mined.
+func WithTTL(ttl time.Duration) func(mp *Mempool) {
+	return func(mp *Mempool) {
+		mp.ttl = ttl
+	}
 }
 
 // New constructs a new mempool using the default sort strategy.
@@ -24,15 +123,28 @@ func New() (*Mempool, error) {
 }
 
 // NewWithStrategy constructs a new mempool with specified sort strategy.
-func NewWithStrategy(strategy string) (*Mempool, error) {
+func NewWithStrategy(strategy string, options ...func(mp *Mempool)) (*Mempool, error) {
 	selectFn, err := selector.Retrieve(strategy)
 	if err != nil {
 		return nil, err
 	}
 
 	mp := Mempool{
-		pool:     make(map[string]database.BlockTx),
-		selectFn: selectFn,
+		pool:       make(map[string]database.BlockTx),
+		perAccount: make(map[database.AccountID]int),
+		pendingTo:  make(map[database.AccountID]uint64),
+		selectFn:   selectFn,
+		eviction:   EvictLowestTip,
+	}
+
+	for _, option := range options {
+		option(&mp)
+	}
+
+	switch mp.eviction {
+	case EvictLowestTip, EvictOldest:
+	default:
+		return nil, fmt.Errorf("eviction policy %q does not exist", mp.eviction)
 	}
 
 	return &mp, nil
@@ -46,6 +158,22 @@ func (mp *Mempool) Count() int {
 	return len(mp.pool)
 }
 
+// PendingCount returns the number of transactions that can be mined in the
+// next block.
+func (mp *Mempool) PendingCount() int {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	var count int
+	for _, tx := range mp.pool {
+		if mp.isPending(tx) {
+			count++
+		}
+	}
+
+	return count
+}
+
 // Upsert adds or replaces a transaction from the mempool.
 func (mp *Mempool) Upsert(tx database.BlockTx) error {
 	mp.mu.Lock()
@@ -57,21 +185,71 @@ func (mp *Mempool) Upsert(tx database.BlockTx) error {
 	// is met, then either the transaction that has the least return on investment
 	// or the oldest will be dropped from the pool to make room for new the transaction.
 
-	// For now, There is no imposing any limits.
+	// The pool can be limited by the number of transactions, the estimated
+	// memory and the transactions per account. Only the transaction with the
+	// highest nonce for an account is ever dropped, so the transactions left
+	// behind can still be mined in nonce order.
 	key, err := mapKey(tx)
 	if err != nil {
 		return err
 	}
 
+	// A transaction for a nonce that is already mined can never succeed.
+	if mp.rejectStale && mp.nonceFn != nil {
+		if nonce := mp.nonceFn(tx.FromID); tx.Nonce <= nonce {
+			return fmt.Errorf("%w: got %d, exp %d", ErrNonceTooLow, tx.Nonce, nonce+1)
+		}
+	}
+
 	// Ethereum requires a 10% bump in the tip to replace an existing
 	// transaction in the mempool and so do we. We want to limit users
 	// from this sort of behavior.
-	if etx, exists := mp.pool[key]; exists {
+	etx, exists := mp.pool[key]
+	if exists {
 		if tx.Tip < uint64(math.Round(float64(etx.Tip)*1.10)) {
-			return errors.New("replacing a transaction requires a 10% bump in the tip")
+			return ErrUnderpriced
 		}
 	}
+
+	if !exists && mp.maxPerAccount > 0 && mp.perAccount[tx.FromID] >= mp.maxPerAccount {
+		return fmt.Errorf("%w: limit %d", ErrAccountLimit, mp.maxPerAccount)
+	}
+
+	// Work out what the pool looks like with the transaction added and drop
+	// transactions until it's within the limits.
+	count := len(mp.pool)
+	bytes := mp.bytes + txSize(tx)
+	if exists {
+		bytes -= txSize(etx)
+	} else {
+		count++
+	}
+
+	evict := make(map[string]bool)
+	for (mp.maxTxs > 0 && count > mp.maxTxs) || (mp.maxBytes > 0 && bytes > mp.maxBytes) {
+		evictKey, found := mp.evictCandidate(tx, evict)
+		if !found {
+			return ErrMempoolFull
+		}
+		evict[evictKey] = true
+		count--
+		bytes -= txSize(mp.pool[evictKey])
+	}
+
+	for evictKey := range evict {
+		mp.remove(evictKey)
+	}
+	if exists {
+		mp.remove(key)
+	}
+
 	mp.pool[key] = tx
+	mp.perAccount[tx.FromID]++
+	mp.bytes += txSize(tx)
+
+	// The transaction could fill the gap that was holding back queued
+	// transactions for the account.
+	mp.extendPending(tx.FromID)
 
 	return nil
 }
@@ -86,7 +264,7 @@ func (mp *Mempool) Delete(tx database.BlockTx) error {
 		return err
 	}
 
-	delete(mp.pool, key)
+	mp.remove(key)
 
 	return nil
 }
@@ -97,6 +275,101 @@ func (mp *Mempool) Truncate() {
 	defer mp.mu.Unlock()
 
 	mp.pool = make(map[string]database.BlockTx)
+	mp.perAccount = make(map[database.AccountID]int)
+	mp.pendingTo = make(map[database.AccountID]uint64)
+	mp.bytes = 0
+}
+
+// Promote brings the pool in line with the account nonces after blocks are
+// added or removed. Transactions for nonces that are now mined are dropped,
+// and queued transactions that have become executable are made pending.
+func (mp *Mempool) Promote() {
+	if mp.nonceFn == nil {
+		return
+	}
+
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	nonces := make(map[database.AccountID]uint64)
+	for accountID := range mp.perAccount {
+		nonces[accountID] = mp.nonceFn(accountID)
+	}
+
+	for key, tx := range mp.pool {
+		if tx.Nonce <= nonces[tx.FromID] {
+			mp.remove(key)
+		}
+	}
+
+	mp.pendingTo = make(map[database.AccountID]uint64)
+	for accountID := range mp.perAccount {
+		mp.extendPending(accountID)
+	}
+}
+
+// Sweep drops the transactions that have been waiting longer than the TTL
+// and the transactions that expire before the next block, which has the
+// specified number. The dropped transactions are returned.
+func (mp *Mempool) Sweep(nextBlock uint64, now time.Time) []database.BlockTx {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	nowMilli := uint64(now.UTC().UnixMilli())
+
+	var dropped []database.BlockTx
+	for key, tx := range mp.pool {
+		stale := mp.ttl > 0 && now.Sub(time.UnixMilli(int64(tx.TimeStamp))) > mp.ttl
+		if stale || tx.Expired(nextBlock, nowMilli) {
+			dropped = append(dropped, tx)
+			mp.remove(key)
+		}
+	}
+
+	return dropped
+}
+
+// Queued returns the transactions waiting on a transaction with a lower
+// nonce, ordered by account and nonce.
+func (mp *Mempool) Queued() []database.BlockTx {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	var queued []database.BlockTx
+	for _, tx := range mp.pool {
+		if mp.isQueued(tx) {
+			queued = append(queued, tx)
+		}
+	}
+
+	sort.Slice(queued, func(i, j int) bool {
+		if queued[i].FromID != queued[j].FromID {
+			return queued[i].FromID < queued[j].FromID
+		}
+		return queued[i].Nonce < queued[j].Nonce
+	})
+
+	return queued
+}
+
+// Account returns the transactions in the pool from the account, ordered by
+// nonce.
+func (mp *Mempool) Account(accountID database.AccountID) []database.BlockTx {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	var txs []database.BlockTx
+	for _, tx := range mp.pool {
+		if tx.FromID == accountID {
+			txs = append(txs, tx)
+		}
+	}
+
+	sort.Slice(txs, func(i, j int) bool {
+		return txs[i].Nonce < txs[j].Nonce
+	})
+
+	return txs
 }
 
 // PickBest uses the configured sort strategy to return a set of transactions.
@@ -128,6 +401,9 @@ func (mp *Mempool) PickBest(howMany ...uint16) []database.BlockTx {
 		}
 
 		for key, tx := range mp.pool {
+			if !mp.isPending(tx) {
+				continue
+			}
 			account := accountFromMapKey(key)
 			m[account] = append(m[account], tx)
 		}
@@ -139,6 +415,141 @@ func (mp *Mempool) PickBest(howMany ...uint16) []database.BlockTx {
 	return mp.selectFn(m, number)
 }
 
+// =============================================================================
+
+// remove deletes the transaction for the key and keeps the accounting in
+// step. The caller must hold the write lock.
+func (mp *Mempool) remove(key string) {
+	tx, exists := mp.pool[key]
+	if !exists {
+		return
+	}
+
+	delete(mp.pool, key)
+	mp.bytes -= txSize(tx)
+	if mp.perAccount[tx.FromID]--; mp.perAccount[tx.FromID] <= 0 {
+		delete(mp.perAccount, tx.FromID)
+		delete(mp.pendingTo, tx.FromID)
+		return
+	}
+
+	// Removing a pending transaction leaves a gap, so the transactions after
+	// it are queued again.
+	if pendingTo, exists := mp.pendingTo[tx.FromID]; exists && tx.Nonce <= pendingTo {
+		mp.pendingTo[tx.FromID] = tx.Nonce - 1
+	}
+}
+
+// extendPending marks the transactions of the account that follow on from
+// the last pending nonce without a gap as pending. The caller must hold the
+// write lock.
+func (mp *Mempool) extendPending(accountID database.AccountID) {
+	if mp.nonceFn == nil {
+		return
+	}
+
+	pendingTo, exists := mp.pendingTo[accountID]
+	if !exists {
+		pendingTo = mp.nonceFn(accountID)
+	}
+
+	for {
+		if _, exists := mp.pool[fmt.Sprintf("%s:%d", accountID, pendingTo+1)]; !exists {
+			break
+		}
+		pendingTo++
+	}
+
+	mp.pendingTo[accountID] = pendingTo
+}
+
+// isPending checks if the transaction can be mined in the next block. A
+// transaction for a nonce that is already mined is never pending, since it
+// would fail and still be charged gas. The caller must hold a lock.
+func (mp *Mempool) isPending(tx database.BlockTx) bool {
+	if mp.nonceFn == nil {
+		return true
+	}
+
+	return tx.Nonce <= mp.pendingTo[tx.FromID] && tx.Nonce > mp.nonceFn(tx.FromID)
+}
+
+// isQueued checks if the transaction is waiting on a transaction with a
+// lower nonce. The caller must hold a lock.
+func (mp *Mempool) isQueued(tx database.BlockTx) bool {
+	if mp.nonceFn == nil {
+		return false
+	}
+
+	return tx.Nonce > mp.pendingTo[tx.FromID]
+}
+
+// evictCandidate picks the transaction to drop to make room for the new
+// transaction based on the eviction policy. Only the transaction with the
+// highest nonce of each account is a candidate, and the new transaction's
+// own account is left alone. Keys already picked for eviction are skipped.
+// The caller must hold the lock.
+func (mp *Mempool) evictCandidate(newTx database.BlockTx, evict map[string]bool) (string, bool) {
+
+	// Find the transaction with the highest nonce for each account.
+	last := make(map[database.AccountID]string)
+	for key, tx := range mp.pool {
+		if evict[key] || tx.FromID == newTx.FromID {
+			continue
+		}
+		if lastKey, exists := last[tx.FromID]; !exists || tx.Nonce > mp.pool[lastKey].Nonce {
+			last[tx.FromID] = key
+		}
+	}
+
+	var candidate string
+	for _, key := range last {
+		tx := mp.pool[key]
+		if candidate == "" {
+			candidate = key
+			continue
+		}
+
+		// Queued and stale transactions are dropped before pending ones.
+		ctx := mp.pool[candidate]
+		if txQueued, ctxQueued := !mp.isPending(tx), !mp.isPending(ctx); txQueued != ctxQueued {
+			if txQueued {
+				candidate = key
+			}
+			continue
+		}
+
+		switch mp.eviction {
+		case EvictOldest:
+			if tx.TimeStamp < ctx.TimeStamp {
+				candidate = key
+			}
+		default:
+			if tx.Tip < ctx.Tip {
+				candidate = key
+			}
+		}
+	}
+
+	if candidate == "" {
+		return "", false
+	}
+
+	// With the lowest tip policy, a pending transaction is only dropped for
+	// one paying a better tip.
+	ctx := mp.pool[candidate]
+	if mp.eviction == EvictLowestTip && mp.isPending(ctx) && ctx.Tip >= newTx.Tip {
+		return "", false
+	}
+
+	return candidate, true
+}
+
+// txSize estimates the number of bytes the transaction takes up in the pool.
+func txSize(tx database.BlockTx) int {
+	return txOverhead + len(tx.Data)
+}
+
 // mapKey is used to generate the map key.
 func mapKey(tx database.BlockTx) (string, error) {
 	return fmt.Sprintf("%s:%d", tx.FromID, tx.Nonce), nil
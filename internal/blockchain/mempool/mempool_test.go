@@ -0,0 +1,115 @@
+package mempool_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/opplieam/bund-blockchain/internal/blockchain/database"
+	"github.com/opplieam/bund-blockchain/internal/blockchain/mempool"
+	"github.com/opplieam/bund-blockchain/internal/blockchain/mempool/selector"
+)
+
+const account = database.AccountID("0xA000000000000000000000000000000000000000")
+
+// minedNonce is the nonce of the last mined transaction for the account.
+const minedNonce = 2
+
+func TestPickBestSkipsStaleNonce(t *testing.T) {
+	mp := newMempool(t, mempool.WithRejectStaleNonce(false))
+
+	// The stale nonce is accepted without strict admission.
+	for _, nonce := range []uint64{minedNonce, minedNonce + 1, minedNonce + 3} {
+		if err := mp.Upsert(blockTx(nonce)); err != nil {
+			t.Fatalf("Upsert nonce %d: %s", nonce, err)
+		}
+	}
+
+	if got := nonces(mp.PickBest()); !equal(got, []uint64{minedNonce + 1}) {
+		t.Errorf("PickBest got nonces %v, exp %v", got, []uint64{minedNonce + 1})
+	}
+
+	if got := mp.PendingCount(); got != 1 {
+		t.Errorf("PendingCount got %d, exp 1", got)
+	}
+
+	if got := nonces(mp.Queued()); !equal(got, []uint64{minedNonce + 3}) {
+		t.Errorf("Queued got nonces %v, exp %v", got, []uint64{minedNonce + 3})
+	}
+
+	// Once the pool is brought in line with the nonces, it's dropped.
+	mp.Promote()
+	if got := mp.Count(); got != 2 {
+		t.Errorf("Count after Promote got %d, exp 2", got)
+	}
+}
+
+func TestUpsertRejectsStaleNonce(t *testing.T) {
+	mp := newMempool(t, mempool.WithRejectStaleNonce(true))
+
+	if err := mp.Upsert(blockTx(minedNonce)); !errors.Is(err, mempool.ErrNonceTooLow) {
+		t.Fatalf("Upsert got error %v, exp %v", err, mempool.ErrNonceTooLow)
+	}
+
+	if got := mp.Count(); got != 0 {
+		t.Errorf("Count got %d, exp 0", got)
+	}
+}
+
+// =============================================================================
+
+// newMempool constructs a mempool where the account's last mined nonce is
+// minedNonce.
+func newMempool(t *testing.T, options ...func(mp *mempool.Mempool)) *mempool.Mempool {
+	t.Helper()
+
+	nonceFn := func(accountID database.AccountID) uint64 {
+		if accountID == account {
+			return minedNonce
+		}
+		return 0
+	}
+
+	options = append([]func(mp *mempool.Mempool){mempool.WithNonceSource(nonceFn)}, options...)
+	mp, err := mempool.NewWithStrategy(selector.StrategyTip, options...)
+	if err != nil {
+		t.Fatalf("NewWithStrategy: %s", err)
+	}
+
+	return mp
+}
+
+// blockTx constructs a transaction from the account with the nonce.
+func blockTx(nonce uint64) database.BlockTx {
+	return database.BlockTx{
+		SignedTx: database.SignedTx{
+			Tx: database.Tx{
+				FromID: account,
+				Nonce:  nonce,
+				Tip:    1,
+			},
+		},
+		GasUnits: 1,
+	}
+}
+
+// nonces returns the nonces of the transactions.
+func nonces(txs []database.BlockTx) []uint64 {
+	out := make([]uint64, len(txs))
+	for i, tx := range txs {
+		out[i] = tx.Nonce
+	}
+	return out
+}
+
+// equal reports whether the two lists of nonces are the same.
+func equal(a, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
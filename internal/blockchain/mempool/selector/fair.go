@@ -0,0 +1,70 @@
+package selector
+
+import (
+	"sort"
+
+	"github.com/opplieam/bund-blockchain/internal/blockchain/database"
+)
+
+// fairAccountCap is the number of transactions an account can have in a
+// block while other accounts still have transactions waiting.
+const fairAccountCap = 4
+
+// fairSelect returns transactions taking turns between the accounts while
+// respecting the nonce for each account/transaction. Each turn takes the next
+// transaction from every account, with the best tip first. An account is
+// capped at fairAccountCap transactions so one busy account can't fill the
+// block. Only when no other account has transactions left does the account
+// get more than the cap.
+var fairSelect = func(m map[database.AccountID][]database.BlockTx, howMany int) []database.BlockTx {
+
+	// Sort the transactions per account by nonce.
+	var total int
+	for key := range m {
+		if len(m[key]) > 1 {
+			sort.Sort(byNonce(m[key]))
+		}
+		total += len(m[key])
+	}
+
+	if howMany == 0 || howMany > total {
+		howMany = total
+	}
+
+	final := []database.BlockTx{}
+	taken := make(map[database.AccountID]int)
+
+	// The first pass honors the cap, the second pass hands out whatever room
+	// is left in the block.
+	for _, capped := range []bool{true, false} {
+		for len(final) < howMany {
+			var row []database.BlockTx
+			for key := range m {
+				if len(m[key]) == 0 || (capped && taken[key] >= fairAccountCap) {
+					continue
+				}
+				row = append(row, m[key][0])
+				m[key] = m[key][1:]
+				taken[key]++
+			}
+			if row == nil {
+				break
+			}
+
+			sort.SliceStable(row, func(i, j int) bool {
+				if row[i].Tip != row[j].Tip {
+					return row[i].Tip > row[j].Tip
+				}
+				return row[i].FromID < row[j].FromID
+			})
+
+			need := howMany - len(final)
+			if len(row) > need {
+				row = row[:need]
+			}
+			final = append(final, row...)
+		}
+	}
+
+	return final
+}
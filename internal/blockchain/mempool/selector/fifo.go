@@ -0,0 +1,14 @@
+package selector
+
+import (
+	"github.com/opplieam/bund-blockchain/internal/blockchain/database"
+)
+
+// fifoSelect returns transactions in the order they were received by the
+// node while respecting the nonce for each account/transaction. A
+// transaction received early can still wait on a lower nonce received later.
+var fifoSelect = func(m map[database.AccountID][]database.BlockTx, howMany int) []database.BlockTx {
+	return bestHeads(m, howMany, func(a, b database.BlockTx) bool {
+		return a.TimeStamp < b.TimeStamp
+	})
+}
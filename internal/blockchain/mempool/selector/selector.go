@@ -2,6 +2,7 @@ package selector
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/opplieam/bund-blockchain/internal/blockchain/database"
@@ -11,12 +12,18 @@ import (
 const (
 	StrategyTip         = "tip"
 	StrategyTipAdvanced = "tip_advanced"
+	StrategyFIFO        = "fifo"
+	StrategyFair        = "fair"
+	StrategyTotalFee    = "total_fee"
 )
 
 // Map of different select strategies with functions.
 var strategies = map[string]Func{
 	StrategyTip:         tipSelect,
 	StrategyTipAdvanced: advancedTipSelect,
+	StrategyFIFO:        fifoSelect,
+	StrategyFair:        fairSelect,
+	StrategyTotalFee:    totalFeeSelect,
 }
 
 // Func defines a function that takes a mempool of transactions grouped by
@@ -36,6 +43,46 @@ func Retrieve(strategy string) (Func, error) {
 
 // =============================================================================
 
+// bestHeads selects howMany transactions by repeatedly taking the best of the
+// transactions at the front of each account's nonce ordered list, as decided
+// by the better function. Since only the front of a list is ever taken, the
+// nonce ordering for each account is kept.
+func bestHeads(m map[database.AccountID][]database.BlockTx, howMany int, better func(a, b database.BlockTx) bool) []database.BlockTx {
+
+	// Sort the transactions per account by nonce.
+	var total int
+	for key := range m {
+		if len(m[key]) > 1 {
+			sort.Sort(byNonce(m[key]))
+		}
+		total += len(m[key])
+	}
+
+	if howMany == 0 || howMany > total {
+		howMany = total
+	}
+
+	final := []database.BlockTx{}
+	for len(final) < howMany {
+		var best database.AccountID
+		for key := range m {
+			if len(m[key]) == 0 {
+				continue
+			}
+			if best == "" || better(m[key][0], m[best][0]) || (!better(m[best][0], m[key][0]) && key < best) {
+				best = key
+			}
+		}
+
+		final = append(final, m[best][0])
+		m[best] = m[best][1:]
+	}
+
+	return final
+}
+
+// =============================================================================
+
 // byNonce provides sorting support by the transaction id value.
 type byNonce []database.BlockTx
 
@@ -0,0 +1,244 @@
+package selector
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/opplieam/bund-blockchain/internal/blockchain/database"
+)
+
+// Accounts used by the tests. Ties are broken by the lower account id, so
+// accountA wins a tie against accountB.
+const (
+	accountA = database.AccountID("0xA000000000000000000000000000000000000000")
+	accountB = database.AccountID("0xB000000000000000000000000000000000000000")
+	accountC = database.AccountID("0xC000000000000000000000000000000000000000")
+)
+
+// testTx describes the fields of a transaction that matter to the selectors.
+type testTx struct {
+	from      database.AccountID
+	nonce     uint64
+	tip       uint64
+	gasPrice  uint64
+	timeStamp uint64
+}
+
+// selectTest describes a mempool and the order the transactions are expected
+// to be selected in, written as account:nonce.
+type selectTest struct {
+	name    string
+	txs     []testTx
+	howMany int
+	want    []string
+}
+
+func TestFIFO(t *testing.T) {
+	tests := []selectTest{
+		{
+			name: "arrival order across accounts",
+			txs: []testTx{
+				{from: accountA, nonce: 1, timeStamp: 10},
+				{from: accountB, nonce: 1, timeStamp: 20},
+				{from: accountA, nonce: 2, timeStamp: 30},
+			},
+			want: []string{"A:1", "B:1", "A:2"},
+		},
+		{
+			name: "nonce order over arrival order",
+			txs: []testTx{
+				{from: accountA, nonce: 2, timeStamp: 10},
+				{from: accountB, nonce: 1, timeStamp: 20},
+				{from: accountA, nonce: 1, timeStamp: 30},
+			},
+			want: []string{"B:1", "A:1", "A:2"},
+		},
+		{
+			name: "same arrival broken by account",
+			txs: []testTx{
+				{from: accountB, nonce: 1, timeStamp: 10},
+				{from: accountA, nonce: 1, timeStamp: 10},
+			},
+			want: []string{"A:1", "B:1"},
+		},
+		{
+			name: "how many",
+			txs: []testTx{
+				{from: accountA, nonce: 1, timeStamp: 10},
+				{from: accountB, nonce: 1, timeStamp: 20},
+				{from: accountC, nonce: 1, timeStamp: 30},
+			},
+			howMany: 2,
+			want:    []string{"A:1", "B:1"},
+		},
+	}
+
+	runSelectTests(t, StrategyFIFO, tests)
+}
+
+func TestFair(t *testing.T) {
+	tests := []selectTest{
+		{
+			name: "turns between accounts",
+			txs: []testTx{
+				{from: accountA, nonce: 1, tip: 10},
+				{from: accountA, nonce: 2, tip: 10},
+				{from: accountA, nonce: 3, tip: 10},
+				{from: accountB, nonce: 1, tip: 1},
+				{from: accountB, nonce: 2, tip: 1},
+			},
+			want: []string{"A:1", "B:1", "A:2", "B:2", "A:3"},
+		},
+		{
+			name: "best tip first in a turn",
+			txs: []testTx{
+				{from: accountA, nonce: 1, tip: 1},
+				{from: accountA, nonce: 2, tip: 20},
+				{from: accountB, nonce: 1, tip: 5},
+				{from: accountB, nonce: 2, tip: 5},
+			},
+			want: []string{"B:1", "A:1", "A:2", "B:2"},
+		},
+		{
+			name: "nonce order over tip",
+			txs: []testTx{
+				{from: accountA, nonce: 3, tip: 30},
+				{from: accountA, nonce: 1, tip: 1},
+				{from: accountA, nonce: 2, tip: 20},
+			},
+			want: []string{"A:1", "A:2", "A:3"},
+		},
+		{
+			name: "same tip broken by account",
+			txs: []testTx{
+				{from: accountC, nonce: 1, tip: 5},
+				{from: accountB, nonce: 1, tip: 5},
+				{from: accountA, nonce: 1, tip: 5},
+			},
+			want: []string{"A:1", "B:1", "C:1"},
+		},
+		{
+			name:    "capped while other accounts wait",
+			txs:     append(accountTxs(accountA, fairAccountCap+2, 100), accountTxs(accountB, fairAccountCap+2, 1)...),
+			howMany: 2 * fairAccountCap,
+			want:    []string{"A:1", "B:1", "A:2", "B:2", "A:3", "B:3", "A:4", "B:4"},
+		},
+		{
+			name: "over the cap once other accounts are empty",
+			txs:  append(accountTxs(accountA, fairAccountCap+2, 1), accountTxs(accountB, 1, 100)...),
+			want: []string{"B:1", "A:1", "A:2", "A:3", "A:4", "A:5", "A:6"},
+		},
+	}
+
+	runSelectTests(t, StrategyFair, tests)
+}
+
+func TestTotalFee(t *testing.T) {
+	tests := []selectTest{
+		{
+			name: "gas fee counts with the tip",
+			txs: []testTx{
+				{from: accountA, nonce: 1, tip: 8, gasPrice: 1},
+				{from: accountB, nonce: 1, tip: 2, gasPrice: 10},
+			},
+			want: []string{"B:1", "A:1"},
+		},
+		{
+			name: "nonce order over total fee",
+			txs: []testTx{
+				{from: accountA, nonce: 2, tip: 100, gasPrice: 1},
+				{from: accountA, nonce: 1, tip: 1, gasPrice: 1},
+				{from: accountB, nonce: 1, tip: 50, gasPrice: 1},
+			},
+			want: []string{"B:1", "A:1", "A:2"},
+		},
+		{
+			name: "same total fee broken by account",
+			txs: []testTx{
+				{from: accountB, nonce: 1, tip: 9, gasPrice: 1},
+				{from: accountA, nonce: 1, tip: 0, gasPrice: 10},
+			},
+			want: []string{"A:1", "B:1"},
+		},
+		{
+			name: "how many",
+			txs: []testTx{
+				{from: accountA, nonce: 1, tip: 1, gasPrice: 1},
+				{from: accountB, nonce: 1, tip: 2, gasPrice: 1},
+				{from: accountC, nonce: 1, tip: 3, gasPrice: 1},
+			},
+			howMany: 2,
+			want:    []string{"C:1", "B:1"},
+		},
+	}
+
+	runSelectTests(t, StrategyTotalFee, tests)
+}
+
+// =============================================================================
+
+// runSelectTests runs the tests against the specified select strategy.
+func runSelectTests(t *testing.T, strategy string, tests []selectTest) {
+	t.Helper()
+
+	fn, err := Retrieve(strategy)
+	if err != nil {
+		t.Fatalf("Retrieve(%q): %s", strategy, err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := make(map[database.AccountID][]database.BlockTx)
+			for _, tx := range tt.txs {
+				m[tx.from] = append(m[tx.from], tx.blockTx())
+			}
+
+			got := fn(m, tt.howMany)
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d transactions %v, exp %d %v", len(got), keys(got), len(tt.want), tt.want)
+			}
+			for i, tx := range keys(got) {
+				if tx != tt.want[i] {
+					t.Fatalf("got %v, exp %v", keys(got), tt.want)
+				}
+			}
+		})
+	}
+}
+
+// accountTxs returns n transactions for the account with the same tip,
+// starting at nonce 1.
+func accountTxs(from database.AccountID, n int, tip uint64) []testTx {
+	txs := make([]testTx, n)
+	for i := range txs {
+		txs[i] = testTx{from: from, nonce: uint64(i + 1), tip: tip}
+	}
+	return txs
+}
+
+// blockTx constructs the block transaction described by the test.
+func (tx testTx) blockTx() database.BlockTx {
+	return database.BlockTx{
+		SignedTx: database.SignedTx{
+			Tx: database.Tx{
+				FromID: tx.from,
+				Nonce:  tx.nonce,
+				Tip:    tx.tip,
+			},
+		},
+		TimeStamp: tx.timeStamp,
+		GasPrice:  tx.gasPrice,
+		GasUnits:  1,
+	}
+}
+
+// keys returns the transactions written as account:nonce, using the first
+// letter of the account after the 0x prefix.
+func keys(txs []database.BlockTx) []string {
+	out := make([]string, len(txs))
+	for i, tx := range txs {
+		out[i] = fmt.Sprintf("%s:%d", tx.FromID[2:3], tx.Nonce)
+	}
+	return out
+}
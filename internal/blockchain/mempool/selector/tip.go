@@ -6,11 +6,11 @@ import (
 	"github.com/opplieam/bund-blockchain/internal/blockchain/database"
 )
 
-// CORE NOTE: On Ethereum a transaction will stay in the mempool and not be selected
-// unless the transaction holds the next expected nonce. Transactions can get stuck
-// in the mempool because of this. This is very complicated for us to implement for
-// now. So we will check the nonce for each transaction when the block is mined.
-// If the nonce is not expected, it will fail but the user continues to pay fees.
+// CORE NOTE: Like on Ethereum, a transaction stays in the mempool and is not
+// selected unless the transaction holds the next expected nonce. The mempool
+// only passes the pending transactions to the selector, which form a run of
+// nonces without gaps for each account. Transactions after a gap are queued in
+// the mempool until the missing nonce shows up.
 
 // tipSelect returns transactions with the best tip while respecting the nonce
 // for each account/transaction.
@@ -0,0 +1,18 @@
+package selector
+
+import (
+	"github.com/opplieam/bund-blockchain/internal/blockchain/database"
+)
+
+// totalFeeSelect returns transactions with the best total fee, the tip plus
+// the gas fee, while respecting the nonce for each account/transaction.
+var totalFeeSelect = func(m map[database.AccountID][]database.BlockTx, howMany int) []database.BlockTx {
+	return bestHeads(m, howMany, func(a, b database.BlockTx) bool {
+		return totalFee(a) > totalFee(b)
+	})
+}
+
+// totalFee returns what the beneficiary earns for mining the transaction.
+func totalFee(tx database.BlockTx) uint64 {
+	return tx.Tip + tx.GasPrice*tx.GasUnits
+}
@@ -183,6 +183,38 @@ func (t *Tree[T]) Proof(data T) ([][]byte, []int64, error) {
 	return nil, nil, errors.New("unable to find data in tree")
 }
 
+// VerifyProof calculates the merkle root from the hash of the data in
+// question and the proof and proof order returned by Proof. An error is
+// returned if the calculated root doesn't match the specified root. This
+// only works for trees using the default sha256 hash strategy.
+func VerifyProof(root []byte, dataHash []byte, proof [][]byte, order []int64) error {
+	if len(proof) != len(order) {
+		return errors.New("proof and proof order are not the same length")
+	}
+
+	hash := dataHash
+	for i, proofHash := range proof {
+		var data []byte
+		switch order[i] {
+		case 0:
+			data = append(append(data, proofHash...), hash...)
+		case 1:
+			data = append(append(data, hash...), proofHash...)
+		default:
+			return fmt.Errorf("invalid proof order %d", order[i])
+		}
+
+		sum := sha256.Sum256(data)
+		hash = sum[:]
+	}
+
+	if !bytes.Equal(hash, root) {
+		return errors.New("merkle root is not equivalent to the merkle root calculated from the proof")
+	}
+
+	return nil
+}
+
 // Verify validates the hashes at each level of the tree and returns true
 // if the resulting hash at the root of the tree matches the resulting root hash.
 func (t *Tree[T]) Verify() error {
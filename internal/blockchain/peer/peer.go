@@ -4,6 +4,7 @@
 package peer
 
 import (
+	"math/big"
 	"sync"
 )
 
@@ -29,9 +30,10 @@ func (p Peer) Match(host string) bool {
 // PeerStatus represents information about the status
 // of any given peer.
 type PeerStatus struct {
-	LatestBlockHash   string `json:"latest_block_hash"`
-	LatestBlockNumber uint64 `json:"latest_block_number"`
-	KnownPeers        []Peer `json:"known_peers"`
+	LatestBlockHash   string   `json:"latest_block_hash"`
+	LatestBlockNumber uint64   `json:"latest_block_number"`
+	TotalWork         *big.Int `json:"total_work"`
+	KnownPeers        []Peer   `json:"known_peers"`
 }
 
 // =============================================================================
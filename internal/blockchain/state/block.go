@@ -3,45 +3,75 @@ package state
 import (
 	"context"
 	"errors"
+	"fmt"
+	"time"
 
 	"github.com/opplieam/bund-blockchain/internal/blockchain/database"
+	"github.com/opplieam/bund-blockchain/internal/blockchain/events"
 )
 
 // ErrNoTransactions is returned when a block is requested to be created
 // and there are not enough transactions.
 var ErrNoTransactions = errors.New("no transactions in mempool")
 
+// ErrBlockNotLatest is returned when a mined block was accepted, but didn't
+// become the latest block in the chain, so it must not be shared.
+var ErrBlockNotLatest = errors.New("mined block is not the latest block")
+
 // MineNewBlock attempts to create a new block with a proper hash that can become
 // the next block in the chain.
 func (s *State) MineNewBlock(ctx context.Context) (database.Block, error) {
-	defer s.evHandler("viewer: MineNewBlock: MINING: completed")
-	s.evHandler("state: MineNewBlock: MINING: check mempool count")
+	defer s.emit(events.Event{Op: "MineNewBlock", Msg: "completed"})
+	s.emit(events.Event{Op: "MineNewBlock", Msg: "check mempool count"})
+
+	// Drop the transactions that can't be mined in the next block.
+	s.SweepMempool()
 
-	// Are there enough transactions in the pool.
-	if s.mempool.Count() == 0 {
+	// Are there enough transactions in the pool that can be mined.
+	if s.mempool.PendingCount() == 0 {
 		return database.Block{}, ErrNoTransactions
 	}
 
 	// Pick the best transactions from the mempool.
 	trans := s.mempool.PickBest(s.genesis.TransPerBlock)
+	if len(trans) == 0 {
+		return database.Block{}, ErrNoTransactions
+	}
 
-	// If PoA is being used, drop the difficulty down to 1 to speed up
-	// the mining operation.
-	difficulty := s.genesis.Difficulty
-	if s.Consensus() == ConsensusPOA {
-		difficulty = 1
+	// The difficulty is adjusted as the chain grows to keep the block time
+	// on target.
+	difficulty, err := s.db.ExpectedDifficulty(s.db.LatestBlock())
+	if err != nil {
+		return database.Block{}, err
 	}
 
-	// Attempt to create a new block by solving the POW puzzle. This can be cancelled.
-	block, err := database.POW(ctx, database.POWArgs{
+	// The timestamp has to be after the median time past of the chain.
+	minTimeStamp, err := s.db.MinTimeStamp(s.db.LatestBlock())
+	if err != nil {
+		return database.Block{}, err
+	}
+
+	args := database.POWArgs{
 		BeneficiaryID: s.beneficiaryID,
 		Difficulty:    difficulty,
-		MiningReward:  s.genesis.MiningReward,
+		MinTimeStamp:  minTimeStamp,
+		MiningReward:  s.db.MiningReward(s.db.LatestBlock().Header.Number + 1),
 		PrevBlock:     s.db.LatestBlock(),
 		StateRoot:     s.db.HashState(),
 		Trans:         trans,
-		EvHandler:     s.evHandler,
-	})
+		Workers:       s.miningWorkers,
+		EvHandler:     s.bus.Publish,
+	}
+
+	// If PoA is being used, the block is signed by this validator instead of
+	// solving the POW puzzle. Otherwise attempt to create a new block by
+	// solving the POW puzzle. This can be cancelled.
+	var block database.Block
+	if s.Consensus() == ConsensusPOA {
+		block, err = database.POA(args, s.signerKey)
+	} else {
+		block, err = database.POW(ctx, args)
+	}
 	if err != nil {
 		return database.Block{}, err
 	}
@@ -51,28 +81,42 @@ func (s *State) MineNewBlock(ctx context.Context) (database.Block, error) {
 		return database.Block{}, ctx.Err()
 	}
 
-	s.evHandler("state: MineNewBlock: MINING: validate and update database")
+	s.emit(events.Event{Op: "MineNewBlock", Msg: "validate and update database", BlockNumber: block.Header.Number})
 
 	// Validate the block and then update the blockchain database.
 	if err := s.validateUpdateDatabase(block); err != nil {
 		return database.Block{}, err
 	}
 
-	return block, nil
+	// The chain could have moved on while mining, in which case the block
+	// was kept on a side branch instead of extending the chain.
+	if latest := s.db.LatestBlock(); latest.Hash() != block.Hash() {
+		return database.Block{}, fmt.Errorf("%w: block[%s]: latest[%s]", ErrBlockNotLatest, block.Hash(), latest.Hash())
+	}
 
+	return block, nil
 }
 
 // ProcessProposedBlock takes a block received from a peer, validates it and
 // if that passes, adds the block to the local blockchain.
 func (s *State) ProcessProposedBlock(block database.Block) error {
-	s.evHandler("state: ValidateProposedBlock: started: prevBlk[%s]: newBlk[%s]: numTrans[%d]", block.Header.PrevBlockHash, block.Hash(), len(block.MerkleTree.Values()))
-	defer s.evHandler("state: ValidateProposedBlock: completed: newBlk[%s]", block.Hash())
+	s.emit(events.Event{Op: "ProcessProposedBlock", Msg: fmt.Sprintf("started: prevBlk[%s]: newBlk[%s]: numTrans[%d]", block.Header.PrevBlockHash, block.Hash(), len(block.MerkleTree.Values())), BlockNumber: block.Header.Number})
+	defer s.emit(events.Event{Op: "ProcessProposedBlock", Msg: "completed", BlockNumber: block.Header.Number})
+
+	latestHash := s.LatestBlock().Hash()
 
 	// Validate the block and then update the blockchain database.
 	if err := s.validateUpdateDatabase(block); err != nil {
+		s.emit(events.Event{Kind: events.KindBlockRejected, Op: "ProcessProposedBlock", Msg: "block rejected", BlockNumber: block.Header.Number, Err: err})
 		return err
 	}
 
+	// If the block ended up on a side branch, our latest block hasn't
+	// changed and there is no reason to stop mining.
+	if s.LatestBlock().Hash() == latestHash {
+		return nil
+	}
+
 	// If the runMiningOperation function is being executed it needs to stop
 	// immediately.
 	s.Worker.SignalCancelMining()
@@ -89,48 +133,107 @@ func (s *State) validateUpdateDatabase(block database.Block) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	s.evHandler("state: validateUpdateDatabase: validate block")
+	err := s.applyBlock(block)
+	if err == nil || block.Header.PrevBlockHash == s.db.LatestBlock().Hash() {
+		return err
+	}
 
-	// CORE NOTE: I could add logic to determine if this block was mined by this
-	// node or a peer. If the block is mined by this node, even if a peer beat
-	// me to this function for the same block number, I could replace the peer
-	// block with my own and attempt to have other peers accept my block instead.
+	// The block doesn't build on our latest block. If it builds on a block we
+	// know about, keep it as part of a side branch.
+	if err := s.db.AddSideBlock(block, s.bus.Publish); err != nil {
+		return err
+	}
 
-	if err := block.ValidateBlock(s.db.LatestBlock(), s.db.HashState(), s.evHandler); err != nil {
+	branch, err := s.db.SideBranch(block.Hash())
+	if err != nil {
 		return err
 	}
 
-	s.evHandler("state: validateUpdateDatabase: write to disk")
+	s.emit(events.Event{Kind: events.KindSideBlock, Op: "validateUpdateDatabase", Msg: fmt.Sprintf("side branch: ancestor[%d]: blocks[%d]", branch.Ancestor, len(branch.Blocks)), BlockNumber: block.Header.Number})
 
-	// Write the new block to the chain on disk.
-	if err := s.db.Write(block); err != nil {
+	// Switch over to the side branch if it holds more work than our chain.
+	work, err := s.db.WorkAfter(branch.Ancestor)
+	if err != nil {
 		return err
 	}
-	s.db.UpdateLatestBlock(block)
+	if branch.Work.Cmp(work) <= 0 {
+		return nil
+	}
 
-	s.evHandler("state: validateUpdateDatabase: update accounts and remove from mempool")
+	s.emit(events.Event{Op: "validateUpdateDatabase", Msg: "side branch has more work: switching", BlockNumber: branch.Ancestor})
 
-	// Process the transactions and update the accounts.
-	for _, tx := range block.MerkleTree.Values() {
-		s.evHandler("state: validateUpdateDatabase: tx[%s] update and remove", tx)
+	return s.reorganize(branch.Ancestor, branch.Blocks)
+}
 
-		// Remove this transaction from the mempool.
-		s.mempool.Delete(tx)
+// applyBlock performs the work of validateUpdateDatabase. The caller must
+// hold the state lock.
+func (s *State) applyBlock(block database.Block) error {
+	s.emit(events.Event{Op: "validateUpdateDatabase", Msg: "validate block", BlockNumber: block.Header.Number})
+
+	// CORE NOTE: I could add logic to determine if this block was mined by this
+	// node or a peer. If the block is mined by this node, even if a peer beat
+	// me to this function for the same block number, I could replace the peer
+	// block with my own and attempt to have other peers accept my block instead.
+
+	if err := s.db.ValidateBlock(block, s.bus.Publish); err != nil {
+		return err
+	}
+
+	s.emit(events.Event{Op: "validateUpdateDatabase", Msg: "update accounts", BlockNumber: block.Header.Number})
+
+	// Process the transactions and update the accounts, recording a receipt
+	// for each transaction.
+	trans := block.MerkleTree.Values()
+	receipts := make([]database.Receipt, len(trans))
+	for i, tx := range trans {
+		s.emit(events.Event{Op: "validateUpdateDatabase", Msg: "update", BlockNumber: block.Header.Number, TxKey: tx.String()})
 
 		// Apply the balance changes based on this transaction.
-		if err := s.db.ApplyTransaction(block, tx); err != nil {
-			s.evHandler("state: validateUpdateDatabase: WARNING : %s", err)
-			continue
+		gasCharged, err := s.db.ApplyTransaction(block, tx)
+		if err != nil {
+			s.emit(events.Event{Kind: events.KindWarning, Op: "validateUpdateDatabase", Msg: "apply transaction", BlockNumber: block.Header.Number, TxKey: tx.String(), Err: err})
 		}
+		receipts[i] = database.NewReceipt(block, i, tx, gasCharged, err)
 	}
 
-	s.evHandler("state: validateUpdateDatabase: apply mining reward")
+	s.emit(events.Event{Op: "validateUpdateDatabase", Msg: "apply mining reward", BlockNumber: block.Header.Number})
 
 	// Apply the mining reward for this block.
 	s.db.ApplyMiningReward(block)
 
+	s.emit(events.Event{Op: "validateUpdateDatabase", Msg: "write to disk", BlockNumber: block.Header.Number})
+
+	// Write the new block to the chain on disk along with the receipts. If
+	// this fails, the changes to the accounts need to be undone.
+	block.Receipts = receipts
+	start := time.Now()
+	err := s.db.Write(block, s.bus.Publish)
+	s.emit(events.Event{Kind: events.KindStorageWrite, Op: "validateUpdateDatabase", Msg: "block written", BlockNumber: block.Header.Number, Duration: time.Since(start), Err: err})
+	if err != nil {
+		s.db.DiscardBlock(block.Header.Number)
+		return err
+	}
+	s.db.UpdateLatestBlock(block)
+
+	s.emit(events.Event{Op: "validateUpdateDatabase", Msg: "remove from mempool", BlockNumber: block.Header.Number})
+
+	// Remove the transactions in this block from the mempool.
+	for _, tx := range trans {
+		s.mempool.Delete(tx)
+	}
+
+	// The new account nonces can make queued transactions executable or
+	// leave others with a nonce that is already used.
+	s.mempool.Promote()
+
+	// Periodically persist the accounts so a restart doesn't require
+	// replaying the whole chain.
+	if err := s.db.WriteSnapshot(); err != nil {
+		s.emit(events.Event{Kind: events.KindWarning, Op: "validateUpdateDatabase", Msg: "snapshot", BlockNumber: block.Header.Number, Err: err})
+	}
+
 	// Send an event about this new block.
-	//s.blockEvent(block)
+	s.emit(events.Event{Kind: events.KindBlockAdded, Op: "validateUpdateDatabase", Msg: "block added", BlockNumber: block.Header.Number, Data: block})
 
 	return nil
 }
@@ -0,0 +1,196 @@
+package state
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/opplieam/bund-blockchain/internal/blockchain/database"
+	"github.com/opplieam/bund-blockchain/internal/blockchain/events"
+)
+
+// CORE NOTE: The feed lets clients follow what happens to the chain without
+// polling. Publishing must never block block processing, so every subscriber
+// gets its own buffered channel. A subscriber that doesn't keep up and lets
+// its buffer fill is dropped and has to subscribe again. The feed is built
+// from the events the state publishes on the event bus.
+
+// Set of topics that can be subscribed to. An account changed topic is
+// followed by a colon and the account id, like accountChanged:0xF01813E4B8...
+const (
+	TopicNewBlocks           = "newBlocks"
+	TopicPendingTransactions = "pendingTransactions"
+	TopicAccountChanged      = "accountChanged"
+)
+
+// FeedEvent represents something that happened to the chain. Only the field
+// matching the topic is set.
+type FeedEvent struct {
+	Topic   string
+	Block   database.Block
+	Tx      database.BlockTx
+	Account database.Account
+}
+
+// Subscription represents a subscriber to the feed.
+type Subscription struct {
+	C <-chan FeedEvent
+
+	ch      chan FeedEvent
+	topics  map[string]bool
+	dropped chan struct{}
+	feed    *feed
+	once    sync.Once
+}
+
+// Dropped returns a channel that is closed when the subscriber is dropped
+// for not keeping up with the events.
+func (sub *Subscription) Dropped() <-chan struct{} {
+	return sub.dropped
+}
+
+// Unsubscribe removes the subscriber from the feed.
+func (sub *Subscription) Unsubscribe() {
+	sub.feed.remove(sub)
+}
+
+// ValidTopic checks the topic is one that can be subscribed to.
+func ValidTopic(topic string) bool {
+	switch topic {
+	case TopicNewBlocks, TopicPendingTransactions:
+		return true
+	}
+
+	accountID, found := strings.CutPrefix(topic, TopicAccountChanged+":")
+	return found && database.AccountID(accountID).IsAccountID()
+}
+
+// Subscribe adds a subscriber to the feed for the specified topics. The
+// buffer is the number of events that can be waiting for the subscriber
+// before it's dropped.
+func (s *State) Subscribe(topics []string, buffer int) *Subscription {
+	ch := make(chan FeedEvent, buffer)
+	sub := Subscription{
+		C:       ch,
+		ch:      ch,
+		topics:  make(map[string]bool),
+		dropped: make(chan struct{}),
+		feed:    s.feed,
+	}
+	for _, topic := range topics {
+		sub.topics[topic] = true
+	}
+
+	s.feed.add(&sub)
+
+	return &sub
+}
+
+// =============================================================================
+
+// feedEvent turns the events about changes to the chain into feed events.
+func (s *State) feedEvent(ev events.Event) {
+	switch ev.Kind {
+	case events.KindBlockAdded:
+		block := ev.Data.(database.Block)
+		s.feed.publish(FeedEvent{Topic: TopicNewBlocks, Block: block})
+		s.publishAccounts(blockAccounts(block))
+
+	case events.KindReorg:
+		// Accounts changed by the orphaned blocks were rolled back.
+		var accountIDs []database.AccountID
+		for _, block := range ev.Data.([]database.Block) {
+			accountIDs = append(accountIDs, blockAccounts(block)...)
+		}
+		s.publishAccounts(accountIDs)
+
+	case events.KindTxAdded:
+		s.feed.publish(FeedEvent{Topic: TopicPendingTransactions, Tx: ev.Data.(database.BlockTx)})
+	}
+}
+
+// publishAccounts sends the current value of the accounts to the feed.
+func (s *State) publishAccounts(accountIDs []database.AccountID) {
+	seen := make(map[database.AccountID]bool)
+	for _, accountID := range accountIDs {
+		if seen[accountID] {
+			continue
+		}
+		seen[accountID] = true
+
+		account, err := s.db.Query(accountID)
+		if err != nil {
+			continue
+		}
+
+		s.feed.publish(FeedEvent{Topic: TopicAccountChanged + ":" + string(accountID), Account: account})
+	}
+}
+
+// blockAccounts returns the ids of the accounts changed by the block.
+func blockAccounts(block database.Block) []database.AccountID {
+	accountIDs := []database.AccountID{block.Header.BeneficiaryID}
+	for _, tx := range block.MerkleTree.Values() {
+		accountIDs = append(accountIDs, tx.FromID, tx.ToID)
+	}
+
+	return accountIDs
+}
+
+// =============================================================================
+
+// feed manages the set of subscribers.
+type feed struct {
+	mu   sync.RWMutex
+	subs map[*Subscription]struct{}
+}
+
+// newFeed constructs a feed for use.
+func newFeed() *feed {
+	return &feed{
+		subs: make(map[*Subscription]struct{}),
+	}
+}
+
+// add registers the subscriber.
+func (f *feed) add(sub *Subscription) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.subs[sub] = struct{}{}
+}
+
+// remove unregisters the subscriber. The subscriber's channel is closed.
+func (f *feed) remove(sub *Subscription) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, exists := f.subs[sub]; exists {
+		delete(f.subs, sub)
+		close(sub.ch)
+	}
+}
+
+// publish sends the event to every subscriber of the topic without blocking.
+// Subscribers with a full buffer are dropped.
+func (f *feed) publish(event FeedEvent) {
+	var slow []*Subscription
+
+	f.mu.RLock()
+	for sub := range f.subs {
+		if !sub.topics[event.Topic] {
+			continue
+		}
+
+		select {
+		case sub.ch <- event:
+		default:
+			slow = append(slow, sub)
+		}
+	}
+	f.mu.RUnlock()
+
+	for _, sub := range slow {
+		sub.once.Do(func() { close(sub.dropped) })
+		f.remove(sub)
+	}
+}
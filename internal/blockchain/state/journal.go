@@ -0,0 +1,89 @@
+package state
+
+import (
+	"fmt"
+
+	"github.com/opplieam/bund-blockchain/internal/blockchain/database"
+	"github.com/opplieam/bund-blockchain/internal/blockchain/events"
+)
+
+// upsertMempool adds the transaction to the mempool and records it in the
+// journal. A failure to write the journal doesn't reject the transaction,
+// the next compaction writes it again.
+func (s *State) upsertMempool(tx database.BlockTx) error {
+	if err := s.mempool.Upsert(tx); err != nil {
+		return err
+	}
+
+	if s.journal != nil {
+		if err := s.journal.Append(tx); err != nil {
+			s.emit(events.Event{Kind: events.KindWarning, Op: "upsertMempool", Msg: "journal", TxKey: tx.String(), Err: err})
+		}
+	}
+
+	return nil
+}
+
+// replayJournal adds the transactions recorded in the journal back to the
+// mempool. They are checked again against the current accounts, so any
+// transaction that was mined, has a stale nonce or has expired is dropped.
+func (s *State) replayJournal() error {
+	txs, err := s.journal.Load()
+	if err != nil {
+		return fmt.Errorf("load mempool journal: %w", err)
+	}
+
+	var added int
+	for _, tx := range txs {
+		if err := s.admitJournalTx(tx); err != nil {
+			s.emit(events.Event{Op: "replayJournal", Msg: "dropped", TxKey: tx.String(), Err: err})
+			continue
+		}
+		added++
+	}
+
+	// Transactions for nonces mined before the restart or that waited too
+	// long are dropped too.
+	s.mempool.Promote()
+	s.SweepMempool()
+
+	s.emit(events.Event{Op: "replayJournal", Msg: fmt.Sprintf("journal[%d]: mempool[%d]", len(txs), added)})
+
+	// The journal is always rewritten, since a crash could have left a
+	// partial line at the end that new appends would be joined to.
+	return s.journal.Compact(s.Mempool)
+}
+
+// admitJournalTx applies the same checks as a transaction received from a
+// node, along with the balance check for strict admission.
+func (s *State) admitJournalTx(tx database.BlockTx) error {
+	if err := tx.Validate(s.genesis.ChainID); err != nil {
+		return err
+	}
+
+	if s.expired(tx.Tx) {
+		return database.ErrTxExpired
+	}
+
+	if s.strict {
+		if err := s.checkFunds(tx); err != nil {
+			return err
+		}
+	}
+
+	return s.mempool.Upsert(tx)
+}
+
+// CompactMempoolJournal rewrites the journal with the transactions in the
+// mempool when it holds transactions that have left the mempool.
+func (s *State) CompactMempoolJournal() error {
+	if s.journal == nil {
+		return nil
+	}
+
+	if s.journal.Entries() <= s.mempool.Count() {
+		return nil
+	}
+
+	return s.journal.Compact(s.Mempool)
+}
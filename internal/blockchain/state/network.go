@@ -7,8 +7,10 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"time"
 
 	"github.com/opplieam/bund-blockchain/internal/blockchain/database"
+	"github.com/opplieam/bund-blockchain/internal/blockchain/events"
 	"github.com/opplieam/bund-blockchain/internal/blockchain/peer"
 )
 
@@ -16,18 +18,18 @@ const baseURL = "http://%s/node"
 
 // NetSendBlockToPeers takes the new mined block and sends it to all know peers.
 func (s *State) NetSendBlockToPeers(block database.Block) error {
-	s.evHandler("state: NetSendBlockToPeers: started")
-	defer s.evHandler("state: NetSendBlockToPeers: completed")
+	s.emit(events.Event{Op: "NetSendBlockToPeers", Msg: "started", BlockNumber: block.Header.Number})
+	defer s.emit(events.Event{Op: "NetSendBlockToPeers", Msg: "completed", BlockNumber: block.Header.Number})
 
 	for _, peer := range s.KnownExternalPeers() {
-		s.evHandler("state: NetSendBlockToPeers: send: block[%s] to peer[%s]", block.Hash(), peer)
+		s.emit(events.Event{Op: "NetSendBlockToPeers", Msg: fmt.Sprintf("send: block[%s]", block.Hash()), BlockNumber: block.Header.Number, Peer: peer.Host})
 
 		url := fmt.Sprintf("%s/block/propose", fmt.Sprintf(baseURL, peer.Host))
 
 		var status struct {
 			Status string `json:"status"`
 		}
-		if err := send(http.MethodPost, url, database.NewBlockData(block), &status); err != nil {
+		if err := s.sendToPeer("NetSendBlockToPeers", peer, http.MethodPost, url, database.NewBlockData(block), &status); err != nil {
 			return fmt.Errorf("%s: %s", peer.Host, err)
 		}
 	}
@@ -37,8 +39,8 @@ func (s *State) NetSendBlockToPeers(block database.Block) error {
 
 // NetSendTxToPeers shares a new block transaction with the known peers.
 func (s *State) NetSendTxToPeers(tx database.BlockTx) {
-	s.evHandler("state: NetSendTxToPeers: started")
-	defer s.evHandler("state: NetSendTxToPeers: completed")
+	s.emit(events.Event{Op: "NetSendTxToPeers", Msg: "started", TxKey: tx.String()})
+	defer s.emit(events.Event{Op: "NetSendTxToPeers", Msg: "completed", TxKey: tx.String()})
 
 	// CORE NOTE: Bitcoin does not send the full transaction immediately to save
 	// on bandwidth. A node will send the transaction's mempool key first so the
@@ -48,12 +50,12 @@ func (s *State) NetSendTxToPeers(tx database.BlockTx) {
 
 	// For now, the Bund blockchain just sends the full transaction.
 	for _, peer := range s.KnownExternalPeers() {
-		s.evHandler("state: NetSendTxToPeers: send: tx[%s] to peer[%s]", tx, peer)
+		s.emit(events.Event{Op: "NetSendTxToPeers", Msg: "send", TxKey: tx.String(), Peer: peer.Host})
 
 		url := fmt.Sprintf("%s/tx/submit", fmt.Sprintf(baseURL, peer.Host))
 
-		if err := send(http.MethodPost, url, tx, nil); err != nil {
-			s.evHandler("state: NetSendTxToPeers: WARNING: %s", err)
+		if err := s.sendToPeer("NetSendTxToPeers", peer, http.MethodPost, url, tx, nil); err != nil {
+			s.emit(events.Event{Kind: events.KindWarning, Op: "NetSendTxToPeers", Msg: "send failed", TxKey: tx.String(), Peer: peer.Host, Err: err})
 		}
 	}
 }
@@ -61,17 +63,17 @@ func (s *State) NetSendTxToPeers(tx database.BlockTx) {
 // NetSendNodeAvailableToPeers shares this node is available to
 // participate in the network with the known peers.
 func (s *State) NetSendNodeAvailableToPeers() {
-	s.evHandler("state: NetSendNodeAvailableToPeers: started")
-	defer s.evHandler("state: NetSendNodeAvailableToPeers: completed")
+	s.emit(events.Event{Op: "NetSendNodeAvailableToPeers", Msg: "started"})
+	defer s.emit(events.Event{Op: "NetSendNodeAvailableToPeers", Msg: "completed"})
 
 	host := peer.Peer{Host: s.Host()}
 
 	for _, peer := range s.KnownExternalPeers() {
-		s.evHandler("state: NetSendNodeAvailableToPeers: send: host[%s] to peer[%s]", host, peer)
+		s.emit(events.Event{Op: "NetSendNodeAvailableToPeers", Msg: fmt.Sprintf("send: host[%s]", host.Host), Peer: peer.Host})
 		url := fmt.Sprintf("%s/peers", fmt.Sprintf(baseURL, peer.Host))
 
-		if err := send(http.MethodPost, url, host, nil); err != nil {
-			s.evHandler("state: NetSendNodeAvailableToPeers: WARNING: %s", err)
+		if err := s.sendToPeer("NetSendNodeAvailableToPeers", peer, http.MethodPost, url, host, nil); err != nil {
+			s.emit(events.Event{Kind: events.KindWarning, Op: "NetSendNodeAvailableToPeers", Msg: "send failed", Peer: peer.Host, Err: err})
 		}
 	}
 }
@@ -79,34 +81,34 @@ func (s *State) NetSendNodeAvailableToPeers() {
 // NetRequestPeerStatus looks for new nodes on the blockchain by asking
 // known nodes for their peer list. New nodes are added to the list.
 func (s *State) NetRequestPeerStatus(pr peer.Peer) (peer.PeerStatus, error) {
-	s.evHandler("state: NetRequestPeerStatus: started: %s", pr)
-	defer s.evHandler("state: NetRequestPeerStatus: completed: %s", pr)
+	s.emit(events.Event{Op: "NetRequestPeerStatus", Msg: "started", Peer: pr.Host})
+	defer s.emit(events.Event{Op: "NetRequestPeerStatus", Msg: "completed", Peer: pr.Host})
 
 	url := fmt.Sprintf("%s/status", fmt.Sprintf(baseURL, pr.Host))
 
 	var ps peer.PeerStatus
-	if err := send(http.MethodGet, url, nil, &ps); err != nil {
+	if err := s.sendToPeer("NetRequestPeerStatus", pr, http.MethodGet, url, nil, &ps); err != nil {
 		return peer.PeerStatus{}, err
 	}
 
-	s.evHandler("state: NetRequestPeerStatus: peer-node[%s]: latest-blknum[%d]: peer-list[%s]", pr, ps.LatestBlockNumber, ps.KnownPeers)
+	s.emit(events.Event{Op: "NetRequestPeerStatus", Msg: fmt.Sprintf("peer-list[%s]", ps.KnownPeers), BlockNumber: ps.LatestBlockNumber, Peer: pr.Host})
 
 	return ps, nil
 }
 
 // NetRequestPeerMempool asks the peer for the transactions in their mempool.
 func (s *State) NetRequestPeerMempool(pr peer.Peer) ([]database.BlockTx, error) {
-	s.evHandler("state: NetRequestPeerMempool: started: %s", pr)
-	defer s.evHandler("state: NetRequestPeerMempool: completed: %s", pr)
+	s.emit(events.Event{Op: "NetRequestPeerMempool", Msg: "started", Peer: pr.Host})
+	defer s.emit(events.Event{Op: "NetRequestPeerMempool", Msg: "completed", Peer: pr.Host})
 
 	url := fmt.Sprintf("%s/tx/list", fmt.Sprintf(baseURL, pr.Host))
 
 	var mempool []database.BlockTx
-	if err := send(http.MethodGet, url, nil, &mempool); err != nil {
+	if err := s.sendToPeer("NetRequestPeerMempool", pr, http.MethodGet, url, nil, &mempool); err != nil {
 		return nil, err
 	}
 
-	s.evHandler("state: NetRequestPeerMempool: len[%d]", len(mempool))
+	s.emit(events.Event{Op: "NetRequestPeerMempool", Msg: fmt.Sprintf("len[%d]", len(mempool)), Peer: pr.Host})
 
 	return mempool, nil
 }
@@ -114,8 +116,8 @@ func (s *State) NetRequestPeerMempool(pr peer.Peer) ([]database.BlockTx, error)
 // NetRequestPeerBlocks queries the specified node asking for blocks this node does
 // not have, then writes them to disk.
 func (s *State) NetRequestPeerBlocks(pr peer.Peer) error {
-	s.evHandler("state: NetRequestPeerBlocks: started: %s", pr)
-	defer s.evHandler("state: NetRequestPeerBlocks: completed: %s", pr)
+	s.emit(events.Event{Op: "NetRequestPeerBlocks", Msg: "started", Peer: pr.Host})
+	defer s.emit(events.Event{Op: "NetRequestPeerBlocks", Msg: "completed", Peer: pr.Host})
 
 	// CORE NOTE: Ideally you want to start by pulling just block headers and
 	// performing the cryptographic audit so you know your're not being attacked.
@@ -129,21 +131,14 @@ func (s *State) NetRequestPeerBlocks(pr peer.Peer) error {
 	// does take place as each full block is downloaded from peers.
 
 	from := s.LatestBlock().Header.Number + 1
-	url := fmt.Sprintf("%s/block/list/%d/latest", fmt.Sprintf(baseURL, pr.Host), from)
-
-	var blocksData []database.BlockData
-	if err := send(http.MethodGet, url, nil, &blocksData); err != nil {
+	blocks, err := s.NetRequestPeerBlockRange(pr, from, QueryLatest)
+	if err != nil {
 		return err
 	}
 
-	s.evHandler("state: NetRequestPeerBlocks: found blocks[%d]", len(blocksData))
-
-	for _, blockData := range blocksData {
-		block, err := database.ToBlock(blockData)
-		if err != nil {
-			return err
-		}
+	s.emit(events.Event{Op: "NetRequestPeerBlocks", Msg: fmt.Sprintf("found blocks[%d]", len(blocks)), Peer: pr.Host})
 
+	for _, block := range blocks {
 		if err := s.ProcessProposedBlock(block); err != nil {
 			return err
 		}
@@ -152,8 +147,48 @@ func (s *State) NetRequestPeerBlocks(pr peer.Peer) error {
 	return nil
 }
 
+// NetRequestPeerBlockRange queries the specified node for the blocks between
+// the from and to block numbers. Passing QueryLatest for to will return all the
+// blocks up to the peer's latest block.
+func (s *State) NetRequestPeerBlockRange(pr peer.Peer, from uint64, to uint64) ([]database.Block, error) {
+	s.emit(events.Event{Op: "NetRequestPeerBlockRange", Msg: "started", BlockNumber: from, Peer: pr.Host})
+	defer s.emit(events.Event{Op: "NetRequestPeerBlockRange", Msg: "completed", Peer: pr.Host})
+
+	toStr := "latest"
+	if to != QueryLatest {
+		toStr = fmt.Sprintf("%d", to)
+	}
+	url := fmt.Sprintf("%s/block/list/%d/%s", fmt.Sprintf(baseURL, pr.Host), from, toStr)
+
+	var blocksData []database.BlockData
+	if err := s.sendToPeer("NetRequestPeerBlockRange", pr, http.MethodGet, url, nil, &blocksData); err != nil {
+		return nil, err
+	}
+
+	blocks := make([]database.Block, len(blocksData))
+	for i, blockData := range blocksData {
+		block, err := database.ToBlock(blockData)
+		if err != nil {
+			return nil, err
+		}
+		blocks[i] = block
+	}
+
+	return blocks, nil
+}
+
 // =============================================================================
 
+// sendToPeer performs the request to the peer for the specified operation and
+// reports how long it took.
+func (s *State) sendToPeer(op string, pr peer.Peer, method string, url string, dataSend any, dataRecv any) error {
+	start := time.Now()
+	err := send(method, url, dataSend, dataRecv)
+	s.emit(events.Event{Kind: events.KindPeerRequest, Op: op, Msg: "request", Peer: pr.Host, Duration: time.Since(start), Err: err})
+
+	return err
+}
+
 // send is a helper function to send an HTTP request to a node.
 func send(method string, url string, dataSend any, dataRecv any) error {
 	var req *http.Request
@@ -194,6 +229,16 @@ func send(method string, url string, dataSend any, dataRecv any) error {
 		if err != nil {
 			return err
 		}
+
+		// Rejections come back with an error code identifying the reason.
+		var rejected struct {
+			Code  string `json:"code"`
+			Error string `json:"error"`
+		}
+		if err := json.Unmarshal(msg, &rejected); err == nil && rejected.Code != "" {
+			return fmt.Errorf("%s: %s", rejected.Code, rejected.Error)
+		}
+
 		return errors.New(string(msg))
 	}
 
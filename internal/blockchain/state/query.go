@@ -2,16 +2,110 @@ package state
 
 import (
 	"github.com/opplieam/bund-blockchain/internal/blockchain/database"
+	"github.com/opplieam/bund-blockchain/internal/blockchain/events"
 )
 
 // QueryLatest represents to query the latest block in the chain.
 const QueryLatest = ^uint64(0) >> 1
 
+// The set of inclusion statuses for blocks and transactions.
+const (
+	StatusMain    = "main"    // The block is part of the main chain.
+	StatusSide    = "side"    // The block is part of a side branch.
+	StatusMined   = "mined"   // The transaction is in a block on the main chain.
+	StatusPending = "pending" // The transaction is waiting in the mempool.
+	StatusQueued  = "queued"  // The transaction is waiting on a lower nonce.
+)
+
+// BlockResult represents a block along with its inclusion status.
+type BlockResult struct {
+	Block         database.Block
+	Status        string
+	Confirmations uint64
+}
+
+// TxResult represents a transaction along with its inclusion status.
+type TxResult struct {
+	Tx            database.BlockTx
+	Status        string
+	BlockNumber   uint64
+	BlockHash     string
+	Index         int
+	Confirmations uint64
+}
+
+// TxProof represents the merkle proof that a transaction is part of a block.
+type TxProof struct {
+	Block database.Block
+	Tx    database.BlockTx
+	Proof [][]byte
+	Order []int64
+}
+
+// AccountTxResult represents a mined transaction that involved an account
+// along with its receipt, if the block has one.
+type AccountTxResult struct {
+	database.AccountTx
+	Tx      database.BlockTx
+	Receipt *database.Receipt
+}
+
 // QueryAccount returns a copy of the account from the database.
 func (s *State) QueryAccount(account database.AccountID) (database.Account, error) {
 	return s.db.Query(account)
 }
 
+// QueryReceipt returns the receipt for the mined transaction with the
+// specified hash.
+func (s *State) QueryReceipt(hash string) (database.Receipt, error) {
+	return s.db.ReceiptByHash(hash)
+}
+
+// QueryAccountAtBlock returns the account as it was after the specified
+// block was applied, along with the block.
+func (s *State) QueryAccountAtBlock(account database.AccountID, num uint64) (database.Account, database.Block, error) {
+
+	// Block 0 is the genesis and is represented by the zero value block.
+	var block database.Block
+	if num > 0 {
+		var err error
+		if block, err = s.db.GetBlock(num); err != nil {
+			return database.Account{}, database.Block{}, database.ErrNotFound
+		}
+	}
+
+	acct, err := s.db.QueryAtBlock(account, num)
+	if err != nil {
+		return database.Account{}, database.Block{}, err
+	}
+
+	return acct, block, nil
+}
+
+// StateRootAfter returns the hash of the accounts after the specified block
+// was applied. A block records the state root from before it was applied,
+// so this is the state root of the next block, or the hash of the current
+// accounts when the block is the latest block.
+func (s *State) StateRootAfter(num uint64) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	latest := s.db.LatestBlock().Header.Number
+	switch {
+	case num > latest:
+		return "", database.ErrNotFound
+	case num == latest:
+		return s.db.HashState(), nil
+	}
+
+	next, err := s.db.GetBlock(num + 1)
+	if err != nil {
+		return "", err
+	}
+
+	return next.Header.StateRoot, nil
+}
+
 // QueryBlocksByNumber returns the set of blocks based on block numbers. This
 // function reads the blockchain from disk first.
 func (s *State) QueryBlocksByNumber(from uint64, to uint64) []database.Block {
@@ -27,7 +121,7 @@ func (s *State) QueryBlocksByNumber(from uint64, to uint64) []database.Block {
 	for i := from; i <= to; i++ {
 		block, err := s.db.GetBlock(i)
 		if err != nil {
-			s.evHandler("state: getblock: ERROR: %s", err)
+			s.emit(events.Event{Kind: events.KindError, Op: "QueryBlocksByNumber", Msg: "get block", BlockNumber: i, Err: err})
 			return nil
 		}
 		out = append(out, block)
@@ -35,3 +129,132 @@ func (s *State) QueryBlocksByNumber(from uint64, to uint64) []database.Block {
 
 	return out
 }
+
+// QueryBlockByHash returns the block with the specified hash. Blocks on the
+// main chain and on side branches are searched.
+func (s *State) QueryBlockByHash(hash string) (BlockResult, error) {
+	if block, exists := s.db.SideBlock(hash); exists {
+		return BlockResult{Block: block, Status: StatusSide}, nil
+	}
+
+	num, err := s.db.BlockNumberByHash(hash)
+	if err != nil {
+		return BlockResult{}, err
+	}
+
+	block, err := s.db.GetBlock(num)
+	if err != nil {
+		return BlockResult{}, err
+	}
+
+	br := BlockResult{
+		Block:         block,
+		Status:        StatusMain,
+		Confirmations: s.db.LatestBlock().Header.Number - num + 1,
+	}
+
+	return br, nil
+}
+
+// QueryTransaction returns the transaction with the specified hash. Mined
+// transactions are searched first, then the mempool.
+func (s *State) QueryTransaction(hash string) (TxResult, error) {
+	tx, loc, err := s.db.TxByHash(hash)
+	if err == nil {
+		block, err := s.db.GetBlock(loc.BlockNumber)
+		if err != nil {
+			return TxResult{}, err
+		}
+
+		tr := TxResult{
+			Tx:            tx,
+			Status:        StatusMined,
+			BlockNumber:   loc.BlockNumber,
+			BlockHash:     block.Hash(),
+			Index:         loc.Index,
+			Confirmations: s.db.LatestBlock().Header.Number - loc.BlockNumber + 1,
+		}
+		return tr, nil
+	}
+
+	for _, tx := range s.mempool.PickBest() {
+		if tx.TxHash() == hash {
+			return TxResult{Tx: tx, Status: StatusPending}, nil
+		}
+	}
+
+	for _, tx := range s.mempool.Queued() {
+		if tx.TxHash() == hash {
+			return TxResult{Tx: tx, Status: StatusQueued}, nil
+		}
+	}
+
+	return TxResult{}, database.ErrNotFound
+}
+
+// QueryTxProof returns the merkle proof for the transaction with the specified
+// hash in the specified block.
+func (s *State) QueryTxProof(blockNum uint64, hash string) (TxProof, error) {
+	block, err := s.db.GetBlock(blockNum)
+	if err != nil {
+		return TxProof{}, database.ErrNotFound
+	}
+
+	for _, tx := range block.MerkleTree.Values() {
+		if tx.TxHash() != hash {
+			continue
+		}
+
+		proof, order, err := block.MerkleTree.Proof(tx)
+		if err != nil {
+			return TxProof{}, err
+		}
+
+		tp := TxProof{
+			Block: block,
+			Tx:    tx,
+			Proof: proof,
+			Order: order,
+		}
+		return tp, nil
+	}
+
+	return TxProof{}, database.ErrNotFound
+}
+
+// QueryAccountTxs returns a page of the mined transactions that involved the
+// account, newest first, filtered by direction.
+func (s *State) QueryAccountTxs(account database.AccountID, direction string, offset int, limit int) ([]AccountTxResult, error) {
+	entries, err := s.db.AccountTxs(account, direction, offset, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	blocks := make(map[uint64]database.Block)
+	results := make([]AccountTxResult, 0, len(entries))
+	for _, entry := range entries {
+		block, exists := blocks[entry.BlockNumber]
+		if !exists {
+			if block, err = s.db.GetBlock(entry.BlockNumber); err != nil {
+				return nil, err
+			}
+			blocks[entry.BlockNumber] = block
+		}
+
+		trans := block.MerkleTree.Values()
+		if entry.Index >= len(trans) {
+			continue
+		}
+
+		result := AccountTxResult{
+			AccountTx: entry,
+			Tx:        trans[entry.Index],
+		}
+		if entry.Index < len(block.Receipts) {
+			result.Receipt = &block.Receipts[entry.Index]
+		}
+		results = append(results, result)
+	}
+
+	return results, nil
+}
@@ -0,0 +1,228 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/opplieam/bund-blockchain/internal/blockchain/database"
+	"github.com/opplieam/bund-blockchain/internal/blockchain/events"
+	"github.com/opplieam/bund-blockchain/internal/blockchain/peer"
+)
+
+// ErrNoBetterChain is returned when a reorganization is requested but no
+// peer has a chain that should replace ours.
+var ErrNoBetterChain = errors.New("no peer with a better chain")
+
+// CORE NOTE: A reorganization happens when this node learns it's on the
+// losing side of a fork. The blocks after the common ancestor are rolled back
+// using the undo information recorded by the database, the transactions in
+// those blocks go back into the mempool, and the winning branch from the peer
+// is applied. If the winning branch turns out to be invalid, our original
+// branch is restored.
+
+// Reorganize corrects an identified fork. The known peers are asked for their
+// status and the peer whose chain holds the most work is used to replace our
+// chain from the common ancestor.
+func (s *State) Reorganize() error {
+	s.emit(events.Event{Op: "Reorganize", Msg: "started"})
+	defer s.emit(events.Event{Op: "Reorganize", Msg: "completed"})
+
+	// Locate the peer with the heaviest chain.
+	var best peer.Peer
+	bestWork := s.TotalWork()
+	for _, pr := range s.KnownExternalPeers() {
+		ps, err := s.NetRequestPeerStatus(pr)
+		if err != nil {
+			s.emit(events.Event{Kind: events.KindWarning, Op: "Reorganize", Msg: "request peer status", Peer: pr.Host, Err: err})
+			continue
+		}
+
+		if ps.TotalWork != nil && ps.TotalWork.Cmp(bestWork) > 0 {
+			best = pr
+			bestWork = ps.TotalWork
+		}
+	}
+
+	if best.Host == "" {
+		return ErrNoBetterChain
+	}
+
+	return s.ReorganizeFromPeer(best)
+}
+
+// ReorganizeFromPeer replaces our chain from the common ancestor with the
+// chain of the specified peer, as long as the peer's branch holds more work
+// than ours. No mining is allowed while this process is running.
+func (s *State) ReorganizeFromPeer(pr peer.Peer) error {
+	s.emit(events.Event{Op: "ReorganizeFromPeer", Msg: "started", Peer: pr.Host})
+	defer s.emit(events.Event{Op: "ReorganizeFromPeer", Msg: "completed", Peer: pr.Host})
+
+	// Don't allow mining to continue.
+	s.allowMining.Store(false)
+	defer s.allowMining.Store(true)
+	s.Worker.SignalCancelMining()
+
+	// Retrieve the peer's blocks covering the range we are able to roll back.
+	from := uint64(1)
+	if latest := s.LatestBlock().Header.Number; latest > database.MaxReorgDepth {
+		from = latest - database.MaxReorgDepth + 1
+	}
+
+	blocks, err := s.NetRequestPeerBlockRange(pr, from, QueryLatest)
+	if err != nil {
+		return err
+	}
+	if len(blocks) == 0 {
+		return ErrNoBetterChain
+	}
+
+	// The blocks come from the peer, so make sure they are the range we asked
+	// for before using their numbers to find the branch.
+	if err := checkBlockRange(blocks, from); err != nil {
+		return fmt.Errorf("peer %s: %w", pr.Host, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ancestor := s.findCommonAncestor(blocks)
+	s.emit(events.Event{Op: "ReorganizeFromPeer", Msg: "common ancestor", BlockNumber: ancestor, Peer: pr.Host})
+
+	// Only the blocks after the common ancestor are part of the peer's branch.
+	branch := blocks[ancestor-blocks[0].Header.Number+1:]
+
+	// Our chain could have moved on since we asked the peer, so compare the
+	// work of both branches after the common ancestor.
+	branchWork := big.NewInt(0)
+	for _, block := range branch {
+		branchWork.Add(branchWork, block.Work())
+	}
+
+	work, err := s.db.WorkAfter(ancestor)
+	if err != nil {
+		return err
+	}
+	if branchWork.Cmp(work) <= 0 {
+		return ErrNoBetterChain
+	}
+
+	return s.reorganize(ancestor, branch)
+}
+
+// =============================================================================
+
+// checkBlockRange validates the blocks start at the specified number and
+// are numbered one after the other.
+func checkBlockRange(blocks []database.Block, from uint64) error {
+	if blocks[0].Header.Number != from {
+		return fmt.Errorf("block range starts at %d, exp %d", blocks[0].Header.Number, from)
+	}
+
+	for i := 1; i < len(blocks); i++ {
+		if exp := blocks[i-1].Header.Number + 1; blocks[i].Header.Number != exp {
+			return fmt.Errorf("block range is not contiguous, got %d, exp %d", blocks[i].Header.Number, exp)
+		}
+	}
+
+	return nil
+}
+
+// findCommonAncestor compares the specified peer blocks against our chain and
+// returns the number of the latest block both chains have in common. If no
+// block matches, the block before the first peer block is assumed to be the
+// common ancestor and will be checked when the branch is applied. The caller
+// must hold the state lock.
+func (s *State) findCommonAncestor(blocks []database.Block) uint64 {
+	first := blocks[0].Header.Number
+	latest := s.db.LatestBlock().Header.Number
+
+	for i := len(blocks) - 1; i >= 0; i-- {
+		num := blocks[i].Header.Number
+		if num > latest {
+			continue
+		}
+
+		block, err := s.db.GetBlock(num)
+		if err != nil {
+			continue
+		}
+
+		if block.Hash() == blocks[i].Hash() {
+			return num
+		}
+	}
+
+	return first - 1
+}
+
+// reorganize rolls our chain back to the specified ancestor and applies the
+// blocks in the branch. If anything fails, our original chain is restored.
+// The caller must hold the state lock.
+func (s *State) reorganize(ancestor uint64, branch []database.Block) error {
+	// Roll back our chain to the common ancestor, keeping the orphaned
+	// blocks so they can be restored if needed.
+	var orphaned []database.Block
+	for s.db.LatestBlock().Header.Number > ancestor {
+		block, err := s.db.RollbackLatestBlock()
+		if err != nil {
+			s.restoreBranch(ancestor, orphaned)
+			return fmt.Errorf("rollback: %w", err)
+		}
+
+		s.emit(events.Event{Op: "reorganize", Msg: "rolled back", BlockNumber: block.Header.Number})
+		orphaned = append(orphaned, block)
+	}
+
+	// Return the orphaned transactions to the mempool. Any transaction
+	// included in the new branch is removed again as the branch is applied.
+	for _, block := range orphaned {
+		for _, tx := range block.MerkleTree.Values() {
+			if err := s.upsertMempool(tx); err != nil {
+				s.emit(events.Event{Kind: events.KindWarning, Op: "reorganize", Msg: "return tx to mempool", TxKey: tx.String(), Err: err})
+			}
+		}
+	}
+	s.mempool.Promote()
+
+	// Apply the winning branch.
+	for _, block := range branch {
+		if err := s.applyBlock(block); err != nil {
+			s.emit(events.Event{Kind: events.KindError, Op: "reorganize", Msg: "apply block", BlockNumber: block.Header.Number, Err: err})
+			s.restoreBranch(ancestor, orphaned)
+			return err
+		}
+	}
+
+	// Keep the orphaned blocks as a side branch in case it becomes the
+	// heavier branch again.
+	for i := len(orphaned) - 1; i >= 0; i-- {
+		if err := s.db.AddSideBlock(orphaned[i], s.bus.Publish); err != nil {
+			s.emit(events.Event{Kind: events.KindWarning, Op: "reorganize", Msg: "keep side block", BlockNumber: orphaned[i].Header.Number, Err: err})
+		}
+	}
+
+	// Send an event about the switch to the new branch.
+	s.emit(events.Event{Kind: events.KindReorg, Op: "reorganize", Msg: fmt.Sprintf("orphaned[%d]: applied[%d]", len(orphaned), len(branch)), BlockNumber: ancestor, Data: orphaned})
+
+	return nil
+}
+
+// restoreBranch rolls back to the specified ancestor and re-applies the
+// orphaned blocks, which are ordered from latest to oldest. The caller must
+// hold the state lock.
+func (s *State) restoreBranch(ancestor uint64, orphaned []database.Block) {
+	for s.db.LatestBlock().Header.Number > ancestor {
+		if _, err := s.db.RollbackLatestBlock(); err != nil {
+			s.emit(events.Event{Kind: events.KindError, Op: "restoreBranch", Msg: "rollback", Err: err})
+			return
+		}
+	}
+
+	for i := len(orphaned) - 1; i >= 0; i-- {
+		if err := s.applyBlock(orphaned[i]); err != nil {
+			s.emit(events.Event{Kind: events.KindError, Op: "restoreBranch", Msg: "apply block", BlockNumber: orphaned[i].Header.Number, Err: err})
+			return
+		}
+	}
+}
@@ -3,9 +3,17 @@
 package state
 
 import (
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"math/big"
+	"runtime"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/opplieam/bund-blockchain/internal/blockchain/database"
+	"github.com/opplieam/bund-blockchain/internal/blockchain/events"
 	"github.com/opplieam/bund-blockchain/internal/blockchain/genesis"
 	"github.com/opplieam/bund-blockchain/internal/blockchain/mempool"
 	"github.com/opplieam/bund-blockchain/internal/blockchain/peer"
@@ -17,10 +25,6 @@ const (
 	ConsensusPOA = "POA"
 )
 
-// EventHandler defines a function that is called when events
-// occur in the processing of persisting blocks.
-type EventHandler func(v string, args ...any)
-
 // Worker interface represents the behavior required to be implemented by any
 // package providing support for mining, peer updates, and transaction sharing.
 type Worker interface {
@@ -29,19 +33,33 @@ type Worker interface {
 	SignalStartMining()
 	SignalCancelMining()
 	SignalShareTx(blockTx database.BlockTx)
+	SignalReorganize()
 }
 
 // Config represents the configuration required to start
 // the blockchain node.
 type Config struct {
 	BeneficiaryID  database.AccountID
+	SignerKey      *ecdsa.PrivateKey
 	Host           string
 	Storage        database.Storage
 	Genesis        genesis.Genesis
 	SelectStrategy string
 	KnownPeers     *peer.PeerSet
-	EvHandler      EventHandler
+	Events         *events.Bus
 	Consensus      string
+	MiningWorkers  int
+
+	SnapshotInterval uint64
+	FullReplay       bool
+
+	MempoolMaxTxs        int
+	MempoolMaxBytes      int
+	MempoolMaxPerAccount int
+	MempoolEviction      string
+	StrictAdmission      bool
+	MempoolTTL           time.Duration
+	MempoolJournal       string
 }
 
 // State manages the blockchain database.
@@ -49,51 +67,136 @@ type State struct {
 	mu sync.RWMutex
 
 	beneficiaryID database.AccountID
+	signerKey     *ecdsa.PrivateKey
 	host          string
-	evHandler     EventHandler
+	bus           *events.Bus
 	consensus     string
+	miningWorkers int
+	strict        bool
+	allowMining   atomic.Bool
 
 	knownPeers *peer.PeerSet
 	storage    database.Storage
 	genesis    genesis.Genesis
 	mempool    *mempool.Mempool
+	journal    *mempool.Journal
 	db         *database.Database
+	feed       *feed
 
 	Worker Worker
 }
 
 // New constructs a new blockchain for data management.
 func New(cfg Config) (*State, error) {
-	// Build a safe event handler function for use.
-	ev := func(v string, args ...any) {
-		if cfg.EvHandler != nil {
-			cfg.EvHandler(v, args...)
-		}
+	// Events are published even when nobody outside the state is listening,
+	// since the feed is built on them.
+	bus := cfg.Events
+	if bus == nil {
+		bus = events.NewBus()
 	}
+
 	// Access the storage for the blockchain.
-	db, err := database.New(cfg.Genesis, cfg.Storage, ev)
+	// Snapshots of the accounts keep the startup time down. A full replay of
+	// the chain can be requested to audit it.
+	options := []func(db *database.Database){
+		database.WithSnapshotInterval(cfg.SnapshotInterval),
+	}
+	if cfg.FullReplay {
+		options = append(options, database.WithFullReplay())
+	}
+
+	db, err := database.New(cfg.Genesis, cfg.Storage, bus.Publish, options...)
 	if err != nil {
 		return nil, err
 	}
-	// Construct a mempool with the specified sort strategy.
-	mempool, err := mempool.NewWithStrategy(cfg.SelectStrategy)
+
+	// The validator set in the genesis file decides if the chain runs under
+	// POA, and a validator needs its key to sign blocks.
+	switch {
+	case cfg.Consensus == ConsensusPOA && len(db.Validators()) == 0:
+		return nil, errors.New("POA requires validators in the genesis file")
+	case cfg.Consensus != ConsensusPOA && len(db.Validators()) > 0:
+		return nil, fmt.Errorf("genesis file declares validators, consensus must be %s", ConsensusPOA)
+	case cfg.Consensus == ConsensusPOA && cfg.SignerKey == nil:
+		return nil, errors.New("POA requires a key to sign blocks")
+	}
+	// Construct a mempool with the specified sort strategy and limits. The
+	// mempool reads the account nonces from the database to know which
+	// transactions can be mined next.
+	nonceFn := func(accountID database.AccountID) uint64 {
+		account, err := db.Query(accountID)
+		if err != nil {
+			return 0
+		}
+		return account.Nonce
+	}
+
+	mempoolOptions := []func(mp *mempool.Mempool){
+		mempool.WithNonceSource(nonceFn),
+		mempool.WithRejectStaleNonce(cfg.StrictAdmission),
+		mempool.WithMaxTxs(cfg.MempoolMaxTxs),
+		mempool.WithMaxBytes(cfg.MempoolMaxBytes),
+		mempool.WithMaxPerAccount(cfg.MempoolMaxPerAccount),
+		mempool.WithTTL(cfg.MempoolTTL),
+	}
+	if cfg.MempoolEviction != "" {
+		mempoolOptions = append(mempoolOptions, mempool.WithEviction(cfg.MempoolEviction))
+	}
+
+	// The journal keeps the mempool across restarts.
+	var journal *mempool.Journal
+	if cfg.MempoolJournal != "" {
+		journal, err = mempool.OpenJournal(cfg.MempoolJournal)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	mempool, err := mempool.NewWithStrategy(cfg.SelectStrategy, mempoolOptions...)
 	if err != nil {
+		if journal != nil {
+			journal.Close()
+		}
 		return nil, err
 	}
 
+	// By default the POW search uses every CPU.
+	miningWorkers := cfg.MiningWorkers
+	if miningWorkers <= 0 {
+		miningWorkers = runtime.NumCPU()
+	}
+
 	// Create the State to provide support for managing the blockchain.
 	state := State{
 		beneficiaryID: cfg.BeneficiaryID,
+		signerKey:     cfg.SignerKey,
 		host:          cfg.Host,
 		storage:       cfg.Storage,
-		evHandler:     ev,
+		bus:           bus,
 		consensus:     cfg.Consensus,
+		miningWorkers: miningWorkers,
+		strict:        cfg.StrictAdmission,
 
 		knownPeers: cfg.KnownPeers,
 		genesis:    cfg.Genesis,
 		mempool:    mempool,
+		journal:    journal,
 		db:         db,
+		feed:       newFeed(),
 	}
+	state.allowMining.Store(true)
+
+	// Clients following the chain are fed from the events.
+	bus.Subscribe(state.feedEvent)
+
+	// Put the transactions that were in the mempool before the restart back.
+	if journal != nil {
+		if err := state.replayJournal(); err != nil {
+			journal.Close()
+			return nil, err
+		}
+	}
+
 	// The Worker is not set here. The call to worker.Run will assign itself
 	// and start everything up and running for the node.
 
@@ -102,8 +205,8 @@ func New(cfg Config) (*State, error) {
 
 // Shutdown cleanly brings the node down.
 func (s *State) Shutdown() error {
-	s.evHandler("state: shutdown: started")
-	defer s.evHandler("state: shutdown: completed")
+	s.emit(events.Event{Op: "Shutdown", Msg: "started"})
+	defer s.emit(events.Event{Op: "Shutdown", Msg: "completed"})
 
 	// Make sure the database file is properly closed.
 	//defer func() {
@@ -113,9 +216,22 @@ func (s *State) Shutdown() error {
 	// Stop all blockchain writing activity.
 	s.Worker.Shutdown()
 
+	// Leave the journal holding just what is in the mempool.
+	if s.journal != nil {
+		if err := s.CompactMempoolJournal(); err != nil {
+			s.emit(events.Event{Kind: events.KindWarning, Op: "Shutdown", Msg: "compact mempool journal", Err: err})
+		}
+		s.journal.Close()
+	}
+
 	return nil
 }
 
+// Events returns the bus the events of the node are published on.
+func (s *State) Events() *events.Bus {
+	return s.bus
+}
+
 // Host returns a copy of host information.
 func (s *State) Host() string {
 	return s.host
@@ -126,24 +242,60 @@ func (s *State) Consensus() string {
 	return s.consensus
 }
 
+// IsMiningAllowed identifies if we are allowed to mine blocks. This
+// might be turned off if the blockchain needs to be reorganized.
+func (s *State) IsMiningAllowed() bool {
+	return s.allowMining.Load()
+}
+
 // LatestBlock returns a copy the current latest block.
 func (s *State) LatestBlock() database.Block {
 	return s.db.LatestBlock()
 }
 
+// IsValidatorTurn checks if this node is the validator expected to produce
+// the next block under POA.
+func (s *State) IsValidatorTurn() bool {
+	validator, exists := s.db.Validator(s.db.LatestBlock().Header.Number + 1)
+	if !exists || s.signerKey == nil {
+		return false
+	}
+
+	return validator == database.PublicKeyToAccountID(s.signerKey.PublicKey)
+}
+
+// NextValidator returns the validator expected to produce the next block
+// under POA.
+func (s *State) NextValidator() database.AccountID {
+	validator, _ := s.db.Validator(s.db.LatestBlock().Header.Number + 1)
+	return validator
+}
+
 // MempoolLength returns the current length of the mempool.
 func (s *State) MempoolLength() int {
 	return s.mempool.Count()
 }
 
-// Mempool returns a copy of the mempool.
+// MempoolPendingLength returns the number of transactions in the mempool
+// that can be mined in the next block.
+func (s *State) MempoolPendingLength() int {
+	return s.mempool.PendingCount()
+}
+
+// TotalWork returns the cumulative work of the chain this node is on.
+func (s *State) TotalWork() *big.Int {
+	return s.db.TotalWork()
+}
+
+// Mempool returns a copy of the mempool. The pending transactions come first
+// in the order they would be mined, followed by the queued transactions.
 func (s *State) Mempool() []database.BlockTx {
-	return s.mempool.PickBest()
+	return append(s.mempool.PickBest(), s.mempool.Queued()...)
 }
 
 // UpsertMempool adds a new transaction to the mempool.
 func (s *State) UpsertMempool(tx database.BlockTx) error {
-	return s.mempool.Upsert(tx)
+	return s.upsertMempool(tx)
 }
 
 // Accounts returns a copy of the database accounts.
@@ -151,6 +303,11 @@ func (s *State) Accounts() map[database.AccountID]database.Account {
 	return s.db.Copy()
 }
 
+// Supply returns the coins in existence as of the latest block.
+func (s *State) Supply() database.Supply {
+	return s.db.Supply()
+}
+
 // Genesis returns a copy of the genesis information.
 func (s *State) Genesis() genesis.Genesis {
 	return s.genesis
@@ -179,3 +336,11 @@ func (s *State) KnownExternalPeers() []peer.Peer {
 func (s *State) KnownPeers() []peer.Peer {
 	return s.knownPeers.Copy("")
 }
+
+// =============================================================================
+
+// emit publishes the event on behalf of the state.
+func (s *State) emit(ev events.Event) {
+	ev.Subsystem = "state"
+	s.bus.Publish(ev)
+}
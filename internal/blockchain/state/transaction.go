@@ -1,16 +1,30 @@
 package state
 
 import (
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	"time"
+
 	"github.com/opplieam/bund-blockchain/internal/blockchain/database"
+	"github.com/opplieam/bund-blockchain/internal/blockchain/events"
 )
 
+// ErrInsufficientFunds is returned by strict admission when the account
+// can't pay for the transaction along with its other transactions in the
+// mempool.
+var ErrInsufficientFunds = errors.New("insufficient funds")
+
 // UpsertWalletTransaction accepts a transaction from a wallet for inclusion.
 func (s *State) UpsertWalletTransaction(signedTx database.SignedTx) error {
 
 	// CORE NOTE: It's up to the wallet to make sure the account has a proper
-	// balance and this transaction has a proper nonce. Fees will be taken if
-	// this transaction is mined into a block it doesn't have enough money to
-	// pay or the nonce isn't the next expected nonce for the account.
+	// balance and nonce. Fees will be taken if this transaction is mined into
+	// a block it doesn't have enough money to pay. With strict admission, a
+	// nonce the account has already used is rejected and the balance is
+	// checked against every transaction the account has in the mempool before
+	// the transaction is accepted.
 
 	// Check the signed transaction has a proper signature, the from matches the
 	// signature, and the from and to fields are properly formatted.
@@ -18,11 +32,23 @@ func (s *State) UpsertWalletTransaction(signedTx database.SignedTx) error {
 		return err
 	}
 
+	if s.expired(signedTx.Tx) {
+		return database.ErrTxExpired
+	}
+
 	const oneUnitOfGas = 1
 	tx := database.NewBlockTx(signedTx, s.genesis.GasPrice, oneUnitOfGas)
-	if err := s.mempool.Upsert(tx); err != nil {
+
+	if s.strict {
+		if err := s.checkFunds(tx); err != nil {
+			return err
+		}
+	}
+
+	if err := s.upsertMempool(tx); err != nil {
 		return err
 	}
+	s.emit(events.Event{Kind: events.KindTxAdded, Op: "UpsertWalletTransaction", Msg: "added to mempool", TxKey: tx.String(), Data: tx})
 
 	s.Worker.SignalShareTx(tx)
 	s.Worker.SignalStartMining()
@@ -39,11 +65,79 @@ func (s *State) UpsertNodeTransaction(tx database.BlockTx) error {
 		return err
 	}
 
-	if err := s.mempool.Upsert(tx); err != nil {
+	if s.expired(tx.Tx) {
+		return database.ErrTxExpired
+	}
+
+	if err := s.upsertMempool(tx); err != nil {
 		return err
 	}
+	s.emit(events.Event{Kind: events.KindTxAdded, Op: "UpsertNodeTransaction", Msg: "added to mempool", TxKey: tx.String(), Data: tx})
 
 	s.Worker.SignalStartMining()
 
 	return nil
 }
+
+// SweepMempool drops the transactions that have been in the mempool longer
+// than the TTL or can't be mined in the next block because they expire.
+func (s *State) SweepMempool() {
+	nextBlock := s.db.LatestBlock().Header.Number + 1
+
+	for _, tx := range s.mempool.Sweep(nextBlock, time.Now()) {
+		s.emit(events.Event{Kind: events.KindTxDropped, Op: "SweepMempool", Msg: "expired", TxKey: tx.String(), Data: tx})
+	}
+}
+
+// =============================================================================
+
+// expired checks if the transaction can't be mined in the next block.
+func (s *State) expired(tx database.Tx) bool {
+	nextBlock := s.db.LatestBlock().Header.Number + 1
+	return tx.Expired(nextBlock, uint64(time.Now().UTC().UnixMilli()))
+}
+
+// checkFunds validates the account's balance covers the value, tip and gas of
+// the transaction along with the other transactions from the account in the
+// mempool. A transaction in the mempool with the same nonce is being replaced
+// and isn't counted. A cost too large to add up can never be covered.
+func (s *State) checkFunds(tx database.BlockTx) error {
+	account, err := s.db.Query(tx.FromID)
+	if err != nil {
+		return fmt.Errorf("%w: account %s has no balance", ErrInsufficientFunds, tx.FromID)
+	}
+
+	needed, ok := txCost(0, tx)
+	for _, pending := range s.mempool.Account(tx.FromID) {
+		if ok && pending.Nonce != tx.Nonce {
+			needed, ok = txCost(needed, pending)
+		}
+	}
+
+	if !ok {
+		return fmt.Errorf("%w: bal %d, needed more than %d", ErrInsufficientFunds, account.Balance, uint64(math.MaxUint64))
+	}
+
+	if account.Balance < needed {
+		return fmt.Errorf("%w: bal %d, needed %d", ErrInsufficientFunds, account.Balance, needed)
+	}
+
+	return nil
+}
+
+// txCost adds the value, tip and gas of the transaction to the total. It
+// reports false if the total overflows.
+func txCost(total uint64, tx database.BlockTx) (uint64, bool) {
+	hi, gas := bits.Mul64(tx.GasPrice, tx.GasUnits)
+	if hi != 0 {
+		return 0, false
+	}
+
+	var carry, c uint64
+	for _, amount := range []uint64{tx.Value, tx.Tip, gas} {
+		total, c = bits.Add64(total, amount, 0)
+		carry |= c
+	}
+
+	return total, carry == 0
+}
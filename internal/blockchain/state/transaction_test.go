@@ -0,0 +1,92 @@
+package state
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/opplieam/bund-blockchain/internal/blockchain/database"
+	"github.com/opplieam/bund-blockchain/internal/blockchain/genesis"
+	"github.com/opplieam/bund-blockchain/internal/blockchain/peer"
+	"github.com/opplieam/bund-blockchain/internal/blockchain/storage/disk"
+)
+
+func TestStrictAdmission(t *testing.T) {
+	const balance = 1_000_000
+
+	tests := []struct {
+		name   string
+		values []uint64
+		err    error
+	}{
+		{name: "covered", values: []uint64{500, 500}},
+		{name: "not covered", values: []uint64{500, balance}, err: ErrInsufficientFunds},
+		{name: "value overflows", values: []uint64{math.MaxUint64}, err: ErrInsufficientFunds},
+		{name: "total overflows", values: []uint64{500, math.MaxUint64 - 100}, err: ErrInsufficientFunds},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pk, err := crypto.GenerateKey()
+			if err != nil {
+				t.Fatalf("GenerateKey: %s", err)
+			}
+			from := database.PublicKeyToAccountID(pk.PublicKey)
+			to := database.AccountID("0xA000000000000000000000000000000000000000")
+
+			st, err := disk.New(t.TempDir())
+			if err != nil {
+				t.Fatalf("disk.New: %s", err)
+			}
+
+			s, err := New(Config{
+				BeneficiaryID:   to,
+				Storage:         st,
+				Genesis:         genesis.Genesis{ChainID: 1, TransPerBlock: 10, Difficulty: 1, GasPrice: 15, Balances: map[string]uint64{string(from): balance}},
+				SelectStrategy:  "tip",
+				KnownPeers:      peer.NewPeerSet(),
+				StrictAdmission: true,
+			})
+			if err != nil {
+				t.Fatalf("New: %s", err)
+			}
+			s.Worker = nopWorker{}
+
+			for i, value := range tt.values {
+				tx, err := database.NewTx(1, uint64(i+1), from, to, value, 1, nil)
+				if err != nil {
+					t.Fatalf("NewTx: %s", err)
+				}
+				signedTx, err := tx.Sign(pk)
+				if err != nil {
+					t.Fatalf("Sign: %s", err)
+				}
+
+				err = s.UpsertWalletTransaction(signedTx)
+				if i < len(tt.values)-1 {
+					if err != nil {
+						t.Fatalf("UpsertWalletTransaction nonce %d: %s", i+1, err)
+					}
+					continue
+				}
+
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("UpsertWalletTransaction got error %v, exp %v", err, tt.err)
+				}
+			}
+		})
+	}
+}
+
+// =============================================================================
+
+// nopWorker stands in for the worker, which isn't needed by the tests.
+type nopWorker struct{}
+
+func (nopWorker) Shutdown()                      {}
+func (nopWorker) Sync()                          {}
+func (nopWorker) SignalStartMining()             {}
+func (nopWorker) SignalCancelMining()            {}
+func (nopWorker) SignalShareTx(database.BlockTx) {}
+func (nopWorker) SignalReorganize()              {}
@@ -9,31 +9,70 @@ import (
 	"io/fs"
 	"os"
 	"path"
+	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/opplieam/bund-blockchain/internal/blockchain/database"
+	"github.com/opplieam/bund-blockchain/internal/blockchain/storage/kv"
+	"github.com/opplieam/bund-blockchain/internal/utils/signature"
+)
+
+// Names used for the files and directories in the database path.
+const (
+	blockExtension = ".json"      // Extension of the file holding a block.
+	tmpExtension   = ".tmp"       // Extension of a file being written.
+	quarantineDir  = "quarantine" // Where unreadable block files are moved on startup.
+	metaDir        = "metadata"   // Where the key value store holding the meta keys lives.
 )
 
 // Disk represents the serialization implementation for reading and storing
 // blocks in their own separate files on disk. This implements the database.Storage
 // interface.
 type Disk struct {
-	dbPath string
+	dbPath      string
+	meta        *kv.KV
+	quarantined []string
 }
 
-// New constructs an Disk value for use.
+// CORE NOTE: A block indexes dozens of meta keys, and writing each of them to
+// its own file with a flush to disk would make every block cost dozens of
+// flushes. The meta keys are kept in an embedded key value store instead,
+// which writes the batch of keys for a block with a single flush.
+
+// New constructs an Disk value for use. Any damage left behind by a crash
+// is cleaned up before the Disk value is returned.
 func New(dbPath string) (*Disk, error) {
 	if err := os.MkdirAll(dbPath, 0755); err != nil {
 		return nil, err
 	}
 
-	return &Disk{dbPath: dbPath}, nil
+	d := Disk{dbPath: dbPath}
+	if err := d.recover(); err != nil {
+		return nil, err
+	}
+
+	meta, err := kv.New(path.Join(dbPath, metaDir))
+	if err != nil {
+		return nil, err
+	}
+	d.meta = meta
+
+	return &d, nil
 }
 
-// Close in this implementation has nothing to do since a new file is
-// written to disk for each now block and then immediately closed.
+// Close closes the store holding the meta keys. A new file is written to
+// disk for each new block and then immediately closed.
 func (d *Disk) Close() error {
-	return nil
+	return d.meta.Close()
+}
+
+// Quarantined returns the names of the block files that were moved to the
+// quarantine directory on startup.
+func (d *Disk) Quarantined() []string {
+	return d.quarantined
 }
 
 // Write takes the specified database blocks and stores it on disk in a
@@ -41,24 +80,21 @@ func (d *Disk) Close() error {
 func (d *Disk) Write(blockData database.BlockData) error {
 
 	// Marshal the block for writing to disk in a more human readable format.
-	data, err := json.MarshalIndent(blockData, "", "  ")
-	if err != nil {
-		return err
+	// A checksum of the block is stored with it so corruption can be detected.
+	bf := blockFile{
+		BlockData: blockData,
+		Checksum:  signature.Hash(blockData),
 	}
-
-	// Create a new file for this block and name it based on the block number.
-	f, err := os.OpenFile(d.getPath(blockData.Header.Number), os.O_CREATE|os.O_RDWR, 0600)
+	data, err := json.MarshalIndent(bf, "", "  ")
 	if err != nil {
 		return err
 	}
-	defer f.Close()
 
-	// Write the new block to disk.
-	if _, err := f.Write(data); err != nil {
-		return err
-	}
+	// CORE NOTE: The block is written to a temporary file which is flushed to
+	// disk and then renamed to the final name. The rename is atomic, so after
+	// a crash the block file either exists with the full content or not at all.
 
-	return nil
+	return writeFile(d.getPath(blockData.Header.Number), data)
 }
 
 // GetBlock searches the blockchain on disk to locate and return the
@@ -73,13 +109,18 @@ func (d *Disk) GetBlock(num uint64) (database.BlockData, error) {
 	defer f.Close()
 
 	// Decode the contents of the block.
-	var blockData database.BlockData
-	if err := json.NewDecoder(f).Decode(&blockData); err != nil {
+	var bf blockFile
+	if err := json.NewDecoder(f).Decode(&bf); err != nil {
 		return database.BlockData{}, err
 	}
 
+	// Blocks written before checksums were introduced don't have one.
+	if bf.Checksum != "" && bf.Checksum != signature.Hash(bf.BlockData) {
+		return database.BlockData{}, fmt.Errorf("block %d checksum mismatch", num)
+	}
+
 	// Return the block as a database block.
-	return blockData, nil
+	return bf.BlockData, nil
 }
 
 // ForEach returns an iterator to walk through all the blocks
@@ -88,19 +129,223 @@ func (d *Disk) ForEach() database.Iterator {
 	return &diskIterator{storage: d}
 }
 
+// Rollback removes the file for the specified block from disk. This is used
+// to remove blocks from the top of the chain during a reorganization.
+func (d *Disk) Rollback(num uint64) error {
+	if err := os.Remove(d.getPath(num)); err != nil {
+		return err
+	}
+
+	return syncDir(d.dbPath)
+}
+
+// PutMeta stores the value for the specified meta key.
+func (d *Disk) PutMeta(key string, value []byte) error {
+	return d.meta.PutMeta(key, value)
+}
+
+// GetMeta returns the value for the specified meta key.
+func (d *Disk) GetMeta(key string) ([]byte, error) {
+	return d.meta.GetMeta(key)
+}
+
+// DeleteMeta removes the specified meta key.
+func (d *Disk) DeleteMeta(key string) error {
+	return d.meta.DeleteMeta(key)
+}
+
+// WriteMeta applies the changes to the meta keys in the batch together.
+func (d *Disk) WriteMeta(batch database.MetaBatch) error {
+	return d.meta.WriteMeta(batch)
+}
+
 // Reset will clear out the blockchain on disk.
 func (d *Disk) Reset() error {
+	if err := d.meta.Close(); err != nil {
+		return err
+	}
+
 	if err := os.RemoveAll(d.dbPath); err != nil {
 		return err
 	}
 
-	return os.MkdirAll(d.dbPath, 0755)
+	if err := os.MkdirAll(d.dbPath, 0755); err != nil {
+		return err
+	}
+
+	meta, err := kv.New(path.Join(d.dbPath, metaDir))
+	if err != nil {
+		return err
+	}
+	d.meta = meta
+
+	return nil
 }
 
 // getPath forms the path to the specified block.
 func (d *Disk) getPath(blockNum uint64) string {
 	name := strconv.FormatUint(blockNum, 10)
-	return path.Join(d.dbPath, fmt.Sprintf("%s.json", name))
+	return path.Join(d.dbPath, fmt.Sprintf("%s%s", name, blockExtension))
+}
+
+// recover removes temporary files left behind by an interrupted write and
+// quarantines block files that don't belong to the chain. These are block
+// files after a gap in the numbering and trailing blocks that are truncated
+// or corrupt.
+func (d *Disk) recover() error {
+	entries, err := os.ReadDir(d.dbPath)
+	if err != nil {
+		return err
+	}
+
+	var nums []uint64
+	for _, entry := range entries {
+		name := entry.Name()
+
+		if strings.HasSuffix(name, tmpExtension) {
+			if err := os.Remove(path.Join(d.dbPath, name)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		num, err := strconv.ParseUint(strings.TrimSuffix(name, blockExtension), 10, 64)
+		if err != nil || !strings.HasSuffix(name, blockExtension) {
+			continue
+		}
+		nums = append(nums, num)
+	}
+	sort.Slice(nums, func(i, j int) bool { return nums[i] < nums[j] })
+
+	// Find the end of the chain, which is the last block before a gap.
+	var latest uint64
+	for _, num := range nums {
+		if num != latest+1 {
+			break
+		}
+		latest = num
+	}
+
+	// Anything after a gap can never be read by the iterator.
+	for _, num := range nums {
+		if num > latest {
+			if err := d.quarantine(num); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Walk back from the end of the chain until a block can be read.
+	for ; latest > 0; latest-- {
+		if _, err := d.GetBlock(latest); err == nil {
+			break
+		}
+		if err := d.quarantine(latest); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// quarantine moves the file for the specified block out of the chain and
+// into the quarantine directory for inspection. The file is given a name
+// with the time it was moved, so files quarantined for the same block by
+// earlier runs are kept.
+func (d *Disk) quarantine(blockNum uint64) error {
+	dir := path.Join(d.dbPath, quarantineDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	name, err := quarantineName(dir, blockNum)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Rename(d.getPath(blockNum), path.Join(dir, name)); err != nil {
+		return err
+	}
+	d.quarantined = append(d.quarantined, name)
+
+	// Flush both directories so the move survives a crash.
+	if err := syncDir(dir); err != nil {
+		return err
+	}
+
+	return syncDir(d.dbPath)
+}
+
+// quarantineName returns a name for the file of the specified block that
+// isn't used in the quarantine directory yet.
+func quarantineName(dir string, blockNum uint64) (string, error) {
+	stamp := time.Now().UTC().Format("20060102T150405.000000000Z")
+
+	for i := 0; ; i++ {
+		name := fmt.Sprintf("%d-%s%s", blockNum, stamp, blockExtension)
+		if i > 0 {
+			name = fmt.Sprintf("%d-%s-%d%s", blockNum, stamp, i, blockExtension)
+		}
+
+		_, err := os.Lstat(path.Join(dir, name))
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			return name, nil
+		case err != nil:
+			return "", err
+		}
+	}
+}
+
+// =============================================================================
+
+// blockFile represents what is stored in a block file on disk. The checksum
+// is a hash of the block data and is verified when the block is read.
+type blockFile struct {
+	database.BlockData
+	Checksum string `json:"checksum"`
+}
+
+// writeFile writes the data to a temporary file which is flushed to disk and
+// then atomically renamed to the specified path.
+func writeFile(path string, data []byte) error {
+	tmpPath := path + tmpExtension
+
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+
+	// Flush the directory so the rename itself survives a crash.
+	return syncDir(filepath.Dir(path))
+}
+
+// syncDir flushes the directory entries of the specified directory to disk.
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return f.Sync()
 }
 
 // =============================================================================
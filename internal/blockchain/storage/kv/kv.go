@@ -0,0 +1,156 @@
+// Package kv implements the ability to read and write blocks to an embedded
+// key value store kept in a single data file with an index.
+package kv
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+
+	"github.com/opplieam/bund-blockchain/internal/blockchain/database"
+)
+
+// Prefixes for the keys held in the store.
+const (
+	blockPrefix = "b/"
+	metaPrefix  = "m/"
+)
+
+// KV represents the serialization implementation for reading and storing
+// blocks in an embedded key value store. This implements the database.Storage
+// interface.
+type KV struct {
+	store *store
+}
+
+// New constructs a KV value for use, opening or creating the store in the
+// specified directory.
+func New(dbPath string) (*KV, error) {
+	st, err := openStore(dbPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &KV{store: st}, nil
+}
+
+// Close writes the index to disk and closes the data file.
+func (kv *KV) Close() error {
+	return kv.store.close()
+}
+
+// Write takes the specified database block and stores it under a key
+// based on the block number.
+func (kv *KV) Write(blockData database.BlockData) error {
+	data, err := json.Marshal(blockData)
+	if err != nil {
+		return err
+	}
+
+	return kv.store.put(blockKey(blockData.Header.Number), data, true)
+}
+
+// GetBlock locates and returns the contents of the specified block by number.
+func (kv *KV) GetBlock(num uint64) (database.BlockData, error) {
+	data, err := kv.store.get(blockKey(num))
+	if err != nil {
+		return database.BlockData{}, fmt.Errorf("block %d: %w", num, err)
+	}
+
+	var blockData database.BlockData
+	if err := json.Unmarshal(data, &blockData); err != nil {
+		return database.BlockData{}, err
+	}
+
+	return blockData, nil
+}
+
+// ForEach returns an iterator to walk through all the blocks
+// starting with block number 1.
+func (kv *KV) ForEach() database.Iterator {
+	return &kvIterator{storage: kv}
+}
+
+// Rollback removes the specified block from the store. This is used to
+// remove blocks from the top of the chain during a reorganization.
+func (kv *KV) Rollback(num uint64) error {
+	return kv.store.delete(blockKey(num), true)
+}
+
+// PutMeta stores the value for the specified meta key.
+func (kv *KV) PutMeta(key string, value []byte) error {
+	return kv.store.put(metaPrefix+key, value, false)
+}
+
+// GetMeta returns the value for the specified meta key.
+func (kv *KV) GetMeta(key string) ([]byte, error) {
+	return kv.store.get(metaPrefix + key)
+}
+
+// DeleteMeta removes the specified meta key.
+func (kv *KV) DeleteMeta(key string) error {
+	err := kv.store.delete(metaPrefix+key, false)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
+
+// WriteMeta applies the changes to the meta keys in the batch together,
+// flushing the data file once for the whole batch.
+func (kv *KV) WriteMeta(batch database.MetaBatch) error {
+	changes := make(map[string][]byte, len(batch))
+	for key, value := range batch {
+		changes[metaPrefix+key] = value
+	}
+
+	return kv.store.apply(changes, true)
+}
+
+// Reset will clear out the blockchain in the store.
+func (kv *KV) Reset() error {
+	return kv.store.reset()
+}
+
+// blockKey forms the key for the specified block.
+func blockKey(blockNum uint64) string {
+	key := make([]byte, len(blockPrefix)+8)
+	copy(key, blockPrefix)
+	binary.BigEndian.PutUint64(key[len(blockPrefix):], blockNum)
+
+	return string(key)
+}
+
+// =============================================================================
+
+// kvIterator represents the iteration implementation for walking through
+// and reading blocks in the store. This implements the database Iterator
+// interface.
+type kvIterator struct {
+	storage *KV    // Access to the storage API.
+	current uint64 // Current block number being iterated over.
+	eoc     bool   // Represents the iterator is at the end of the chain.
+}
+
+// Next retrieves the next block from the store.
+func (ki *kvIterator) Next() (database.BlockData, error) {
+	if ki.eoc {
+		return database.BlockData{}, errors.New("end of chain")
+	}
+
+	ki.current++
+	blockData, err := ki.storage.GetBlock(ki.current)
+	if errors.Is(err, fs.ErrNotExist) {
+		ki.eoc = true
+	}
+
+	return blockData, err
+}
+
+// Done returns the end of chain value.
+func (ki *kvIterator) Done() bool {
+	return ki.eoc
+}
@@ -0,0 +1,595 @@
+package kv
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// CORE NOTE: The store is an append-only log of records in a single data file.
+// Every put or delete appends a record and an in-memory index maps each key to
+// the location of its latest record, so a read is a single positioned read.
+// On close the index is written to an index file alongside the data so the
+// next start doesn't need to scan the data file. Records written after the
+// index file are recovered by scanning from the point the index covers. The
+// space held by overwritten and deleted records is reclaimed by compacting
+// the data file on startup.
+
+// Names of the files the store maintains.
+const (
+	dataFile  = "bund.db"
+	indexFile = "bund.idx"
+)
+
+// Record kinds stored in the data file.
+const (
+	recordPut    byte = 1
+	recordDelete byte = 2
+)
+
+// headerSize is the size of a record header: crc(4) kind(1) keyLen(4) valLen(4).
+const headerSize = 13
+
+// compactThreshold is the minimum number of dead bytes in the data file
+// before compaction is considered on startup.
+const compactThreshold = 1 << 20
+
+// errNotFound is returned when a key doesn't exist in the store.
+var errNotFound = fmt.Errorf("key not found: %w", fs.ErrNotExist)
+
+// location represents where the value for a key lives in the data file.
+type location struct {
+	offset int64  // Offset of the record header in the data file.
+	keyLen uint32 // Length of the key.
+	valLen uint32 // Length of the value.
+}
+
+// size returns the total size of the record on disk.
+func (l location) size() int64 {
+	return headerSize + int64(l.keyLen) + int64(l.valLen)
+}
+
+// store represents a log structured key value store kept in a single file.
+type store struct {
+	mu    sync.RWMutex
+	dir   string
+	data  *os.File
+	size  int64               // Size of the data file, the next write offset.
+	dead  int64               // Bytes held by overwritten or deleted records.
+	index map[string]location // Location of the latest record for each key.
+}
+
+// openStore opens the store in the specified directory, creating the data
+// file if it doesn't exist, and loads the index.
+func openStore(dir string) (*store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	data, err := os.OpenFile(filepath.Join(dir, dataFile), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	st := store{
+		dir:   dir,
+		data:  data,
+		index: make(map[string]location),
+	}
+
+	if err := st.load(); err != nil {
+		data.Close()
+		return nil, err
+	}
+
+	if st.dead > compactThreshold && st.dead > st.size/2 {
+		if err := st.compact(); err != nil {
+			data.Close()
+			return nil, err
+		}
+	}
+
+	return &st, nil
+}
+
+// close writes the index file and closes the data file.
+func (st *store) close() error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if err := st.writeIndex(); err != nil {
+		st.data.Close()
+		return err
+	}
+
+	return st.data.Close()
+}
+
+// get returns the value for the specified key.
+func (st *store) get(key string) ([]byte, error) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	loc, exists := st.index[key]
+	if !exists {
+		return nil, errNotFound
+	}
+
+	buf := make([]byte, loc.size())
+	if _, err := st.data.ReadAt(buf, loc.offset); err != nil {
+		return nil, err
+	}
+
+	kind, recKey, value, err := decodeRecord(buf)
+	if err != nil {
+		return nil, fmt.Errorf("reading key %q: %w", key, err)
+	}
+	if kind != recordPut || string(recKey) != key {
+		return nil, fmt.Errorf("reading key %q: index points to the wrong record", key)
+	}
+
+	return value, nil
+}
+
+// put stores the value for the specified key. When sync is true the data
+// file is flushed to stable storage before returning.
+func (st *store) put(key string, value []byte, sync bool) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	loc, err := st.append(recordPut, key, value, sync)
+	if err != nil {
+		return err
+	}
+
+	if old, exists := st.index[key]; exists {
+		st.dead += old.size()
+	}
+	st.index[key] = loc
+
+	return nil
+}
+
+// delete removes the specified key from the store.
+func (st *store) delete(key string, sync bool) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	old, exists := st.index[key]
+	if !exists {
+		return errNotFound
+	}
+
+	loc, err := st.append(recordDelete, key, nil, sync)
+	if err != nil {
+		return err
+	}
+
+	st.dead += old.size() + loc.size()
+	delete(st.index, key)
+
+	return nil
+}
+
+// apply stores or removes every key in the changes, a nil value removes the
+// key. The records are written with a single write, and when sync is true
+// the data file is flushed to stable storage once for all of them.
+func (st *store) apply(changes map[string][]byte, sync bool) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	type change struct {
+		key  string
+		kind byte
+		loc  location
+	}
+
+	var buf []byte
+	var applied []change
+	for key, value := range changes {
+		kind := recordPut
+		if value == nil {
+			if _, exists := st.index[key]; !exists {
+				continue
+			}
+			kind = recordDelete
+		}
+
+		loc := location{
+			offset: st.size + int64(len(buf)),
+			keyLen: uint32(len(key)),
+			valLen: uint32(len(value)),
+		}
+		buf = append(buf, encodeRecord(kind, []byte(key), value)...)
+		applied = append(applied, change{key: key, kind: kind, loc: loc})
+	}
+
+	if len(buf) == 0 {
+		return nil
+	}
+
+	if _, err := st.data.WriteAt(buf, st.size); err != nil {
+		return err
+	}
+	if sync {
+		if err := st.data.Sync(); err != nil {
+			return err
+		}
+	}
+	st.size += int64(len(buf))
+
+	// The index is only updated once the records are written.
+	for _, c := range applied {
+		if old, exists := st.index[c.key]; exists {
+			st.dead += old.size()
+		}
+
+		switch c.kind {
+		case recordPut:
+			st.index[c.key] = c.loc
+		default:
+			st.dead += c.loc.size()
+			delete(st.index, c.key)
+		}
+	}
+
+	return nil
+}
+
+// reset removes all the keys from the store.
+func (st *store) reset() error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if err := st.data.Truncate(0); err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(st.dir, indexFile)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	st.size = 0
+	st.dead = 0
+	st.index = make(map[string]location)
+
+	return nil
+}
+
+// =============================================================================
+
+// append writes a new record to the end of the data file. The caller must
+// hold the write lock.
+func (st *store) append(kind byte, key string, value []byte, sync bool) (location, error) {
+	rec := encodeRecord(kind, []byte(key), value)
+
+	if _, err := st.data.WriteAt(rec, st.size); err != nil {
+		return location{}, err
+	}
+
+	if sync {
+		if err := st.data.Sync(); err != nil {
+			return location{}, err
+		}
+	}
+
+	loc := location{
+		offset: st.size,
+		keyLen: uint32(len(key)),
+		valLen: uint32(len(value)),
+	}
+	st.size += int64(len(rec))
+
+	return loc, nil
+}
+
+// load builds the index from the index file, if one exists, and then scans
+// the rest of the data file for records written after the index file.
+func (st *store) load() error {
+	info, err := st.data.Stat()
+	if err != nil {
+		return err
+	}
+	fileSize := info.Size()
+
+	// An index file that doesn't match the data file is ignored and the
+	// whole data file is scanned instead.
+	covered, err := st.readIndex(fileSize)
+	if err != nil {
+		st.index = make(map[string]location)
+		st.dead = 0
+		covered = 0
+	}
+
+	return st.scan(covered, fileSize)
+}
+
+// scan reads the records in the data file starting at the specified offset
+// and adds them to the index. A partial or corrupt record at the end of the
+// file is the result of a crash during a write and is truncated.
+func (st *store) scan(offset int64, fileSize int64) error {
+	r := bufio.NewReader(io.NewSectionReader(st.data, offset, fileSize-offset))
+
+	for offset < fileSize {
+		header := make([]byte, headerSize)
+		if _, err := io.ReadFull(r, header); err != nil {
+			break
+		}
+
+		keyLen := binary.BigEndian.Uint32(header[5:9])
+		valLen := binary.BigEndian.Uint32(header[9:13])
+		if int64(keyLen)+int64(valLen) > fileSize-offset-headerSize {
+			break
+		}
+
+		rec := make([]byte, headerSize+int(keyLen)+int(valLen))
+		copy(rec, header)
+		if _, err := io.ReadFull(r, rec[headerSize:]); err != nil {
+			break
+		}
+
+		kind, key, _, err := decodeRecord(rec)
+		if err != nil {
+			break
+		}
+
+		loc := location{offset: offset, keyLen: keyLen, valLen: valLen}
+		if old, exists := st.index[string(key)]; exists {
+			st.dead += old.size()
+		}
+
+		switch kind {
+		case recordPut:
+			st.index[string(key)] = loc
+		case recordDelete:
+			delete(st.index, string(key))
+			st.dead += loc.size()
+		}
+
+		offset += loc.size()
+	}
+
+	// Drop anything after the last good record.
+	if offset < fileSize {
+		if err := st.data.Truncate(offset); err != nil {
+			return err
+		}
+	}
+	st.size = offset
+
+	return nil
+}
+
+// compact rewrites the data file with only the live records and replaces
+// the existing data file.
+func (st *store) compact() error {
+	path := filepath.Join(st.dir, dataFile)
+	tmpPath := path + ".compact"
+
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+
+	w := bufio.NewWriter(tmp)
+	index := make(map[string]location, len(st.index))
+	var offset int64
+
+	for key, loc := range st.index {
+		rec := make([]byte, loc.size())
+		if _, err := st.data.ReadAt(rec, loc.offset); err != nil {
+			tmp.Close()
+			return err
+		}
+		if _, err := w.Write(rec); err != nil {
+			tmp.Close()
+			return err
+		}
+
+		index[key] = location{offset: offset, keyLen: loc.keyLen, valLen: loc.valLen}
+		offset += loc.size()
+	}
+
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	// The index file refers to the old data file, so it has to be gone
+	// before the new data file takes its place.
+	if err := os.Remove(filepath.Join(st.dir, indexFile)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		tmp.Close()
+		return err
+	}
+	if err := syncDir(st.dir); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	// Flush the directory so the rename itself survives a crash.
+	if err := syncDir(st.dir); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	st.data.Close()
+	st.data = tmp
+	st.size = offset
+	st.dead = 0
+	st.index = index
+
+	return nil
+}
+
+// =============================================================================
+
+// writeIndex writes the index to the index file. The file starts with the
+// size of the data file it covers and the dead bytes, followed by one entry
+// per key, and ends with a checksum of the content. The data file is flushed
+// first so the index never points at records that aren't on disk. The
+// caller must hold the write lock.
+func (st *store) writeIndex() error {
+	if err := st.data.Sync(); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+
+	binary.Write(&buf, binary.BigEndian, st.size)
+	binary.Write(&buf, binary.BigEndian, st.dead)
+	for key, loc := range st.index {
+		binary.Write(&buf, binary.BigEndian, loc.keyLen)
+		buf.WriteString(key)
+		binary.Write(&buf, binary.BigEndian, loc.offset)
+		binary.Write(&buf, binary.BigEndian, loc.valLen)
+	}
+	binary.Write(&buf, binary.BigEndian, crc32.ChecksumIEEE(buf.Bytes()))
+
+	path := filepath.Join(st.dir, indexFile)
+	tmpPath := path + ".tmp"
+	if err := writeFileSync(tmpPath, buf.Bytes()); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+
+	// Flush the directory so the rename itself survives a crash.
+	return syncDir(st.dir)
+}
+
+// writeFileSync writes the data to the named file and flushes it to stable
+// storage before closing it.
+func writeFileSync(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// syncDir flushes the directory entries of the specified directory to disk.
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return f.Sync()
+}
+
+// readIndex loads the index file and returns the size of the data file
+// the index covers.
+func (st *store) readIndex(fileSize int64) (int64, error) {
+	content, err := os.ReadFile(filepath.Join(st.dir, indexFile))
+	if err != nil {
+		return 0, err
+	}
+
+	if len(content) < 20 {
+		return 0, errors.New("index file too small")
+	}
+
+	body, sum := content[:len(content)-4], content[len(content)-4:]
+	if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(sum) {
+		return 0, errors.New("index file checksum mismatch")
+	}
+
+	covered := int64(binary.BigEndian.Uint64(body[0:8]))
+	if covered > fileSize {
+		return 0, errors.New("index file covers more than the data file")
+	}
+	st.dead = int64(binary.BigEndian.Uint64(body[8:16]))
+
+	body = body[16:]
+	for len(body) > 0 {
+		if len(body) < 4 {
+			return 0, errors.New("index file entry truncated")
+		}
+		keyLen := binary.BigEndian.Uint32(body[0:4])
+		body = body[4:]
+
+		if uint64(len(body)) < uint64(keyLen)+12 {
+			return 0, errors.New("index file entry truncated")
+		}
+		key := string(body[:keyLen])
+		body = body[keyLen:]
+
+		st.index[key] = location{
+			offset: int64(binary.BigEndian.Uint64(body[0:8])),
+			keyLen: keyLen,
+			valLen: binary.BigEndian.Uint32(body[8:12]),
+		}
+		body = body[12:]
+	}
+
+	return covered, nil
+}
+
+// =============================================================================
+
+// encodeRecord constructs a record in the format stored in the data file.
+func encodeRecord(kind byte, key []byte, value []byte) []byte {
+	rec := make([]byte, headerSize+len(key)+len(value))
+	rec[4] = kind
+	binary.BigEndian.PutUint32(rec[5:9], uint32(len(key)))
+	binary.BigEndian.PutUint32(rec[9:13], uint32(len(value)))
+	copy(rec[headerSize:], key)
+	copy(rec[headerSize+len(key):], value)
+
+	binary.BigEndian.PutUint32(rec[0:4], crc32.ChecksumIEEE(rec[4:]))
+
+	return rec
+}
+
+// decodeRecord validates the checksum of the record and splits it into
+// its parts.
+func decodeRecord(rec []byte) (kind byte, key []byte, value []byte, err error) {
+	if len(rec) < headerSize {
+		return 0, nil, nil, errors.New("record too small")
+	}
+
+	if crc32.ChecksumIEEE(rec[4:]) != binary.BigEndian.Uint32(rec[0:4]) {
+		return 0, nil, nil, errors.New("record checksum mismatch")
+	}
+
+	keyLen := binary.BigEndian.Uint32(rec[5:9])
+	valLen := binary.BigEndian.Uint32(rec[9:13])
+	if uint64(len(rec)) != headerSize+uint64(keyLen)+uint64(valLen) {
+		return 0, nil, nil, errors.New("record length mismatch")
+	}
+
+	key = rec[headerSize : headerSize+keyLen]
+	value = rec[headerSize+keyLen:]
+
+	return rec[4], key, value, nil
+}
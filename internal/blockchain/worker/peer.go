@@ -1,6 +1,7 @@
 package worker
 
 import (
+	"github.com/opplieam/bund-blockchain/internal/blockchain/events"
 	"github.com/opplieam/bund-blockchain/internal/blockchain/peer"
 )
 
@@ -14,8 +15,8 @@ import (
 
 // peerOperations handles finding new peers.
 func (w *Worker) peerOperations() {
-	w.evHandler("worker: peerOperations: G started")
-	defer w.evHandler("worker: peerOperations: G completed")
+	w.emit(events.Event{Op: "peerOperations", Msg: "G started"})
+	defer w.emit(events.Event{Op: "peerOperations", Msg: "G completed"})
 
 	// On startup talk to the origin node and get an updated
 	// peers list. Then share with the network that this node
@@ -31,7 +32,7 @@ func (w *Worker) peerOperations() {
 				w.runPeersOperation()
 			}
 		case <-w.shut:
-			w.evHandler("worker: peerOperations: received shut signal")
+			w.emit(events.Event{Op: "peerOperations", Msg: "received shut signal"})
 			return
 		}
 	}
@@ -39,15 +40,15 @@ func (w *Worker) peerOperations() {
 
 // runPeersOperation updates the peer list.
 func (w *Worker) runPeersOperation() {
-	w.evHandler("worker: runPeersOperation: started")
-	defer w.evHandler("worker: runPeersOperation: completed")
+	w.emit(events.Event{Op: "runPeersOperation", Msg: "started"})
+	defer w.emit(events.Event{Op: "runPeersOperation", Msg: "completed"})
 
 	for _, peer := range w.state.KnownExternalPeers() {
 
 		// Retrieve the status of this peer.
 		peerStatus, err := w.state.NetRequestPeerStatus(peer)
 		if err != nil {
-			w.evHandler("worker: runPeersOperation: requestPeerStatus: %s: ERROR: %s", peer.Host, err)
+			w.emit(events.Event{Kind: events.KindError, Op: "runPeersOperation", Msg: "request peer status", Peer: peer.Host, Err: err})
 
 			// Since this peer is unavailable, remove them from the list.
 			w.state.RemoveKnownPeer(peer)
@@ -66,8 +67,8 @@ func (w *Worker) runPeersOperation() {
 // addNewPeers takes the list of known peers and makes sure they are included
 // in the nodes list of know peers.
 func (w *Worker) addNewPeers(knownPeers []peer.Peer) error {
-	w.evHandler("worker: runPeerUpdatesOperation: addNewPeers: started")
-	defer w.evHandler("worker: runPeerUpdatesOperation: addNewPeers: completed")
+	w.emit(events.Event{Op: "addNewPeers", Msg: "started"})
+	defer w.emit(events.Event{Op: "addNewPeers", Msg: "completed"})
 
 	for _, peer := range knownPeers {
 
@@ -78,7 +79,7 @@ func (w *Worker) addNewPeers(knownPeers []peer.Peer) error {
 
 		// Only log when the peer is new.
 		if w.state.AddKnownPeer(peer) {
-			w.evHandler("worker: runPeerUpdatesOperation: addNewPeers: add peer nodes: adding peer-node %s", peer.Host)
+			w.emit(events.Event{Kind: events.KindPeerAdded, Op: "addNewPeers", Msg: "adding peer-node", Peer: peer.Host})
 		}
 	}
 
@@ -3,19 +3,20 @@ package worker
 import (
 	"context"
 	"errors"
-	"hash/fnv"
-	"sort"
+	"fmt"
 	"sync"
 	"time"
 
+	"github.com/opplieam/bund-blockchain/internal/blockchain/events"
 	"github.com/opplieam/bund-blockchain/internal/blockchain/state"
 )
 
 // CORE NOTE: The POA mining operation is managed by this function which runs on
 // it's own goroutine. The node starts a loop that is on a 12 second timer. At
-// the beginning of each cycle the selection algorithm is executed which determines
-// if this node needs to mine the next block. If this node is not selected, it
-// waits for the next cycle to check the selection algorithm again.
+// the beginning of each cycle the node checks if it's the validator whose turn
+// it is to produce the next block. If it's not, it waits for the next cycle to
+// check again. The validators take turns by block height in the order they are
+// listed in the genesis file.
 
 // cycleDuration sets the mining operation to happen every 12 seconds
 const secondsPerCycle = 12
@@ -23,8 +24,8 @@ const cycleDuration = secondsPerCycle * time.Second
 
 // poaOperations handles mining.
 func (w *Worker) poaOperations() {
-	w.evHandler("worker: poaOperations: G started")
-	defer w.evHandler("worker: poaOperations: G completed")
+	w.emit(events.Event{Op: "poaOperations", Msg: "G started"})
+	defer w.emit(events.Event{Op: "poaOperations", Msg: "G completed"})
 
 	ticker := time.NewTicker(cycleDuration)
 
@@ -38,7 +39,7 @@ func (w *Worker) poaOperations() {
 				w.runPoaOperation()
 			}
 		case <-w.shut:
-			w.evHandler("worker: poaOperations: received shut signal")
+			w.emit(events.Event{Op: "poaOperations", Msg: "received shut signal"})
 			return
 		}
 
@@ -50,35 +51,34 @@ func (w *Worker) poaOperations() {
 // runPoaOperation takes all the transactions from the mempool and writes a
 // new block to the database.
 func (w *Worker) runPoaOperation() {
-	w.evHandler("worker: runPoaOperation: started")
-	defer w.evHandler("worker: runPoaOperation: completed")
+	w.emit(events.Event{Op: "runPoaOperation", Msg: "started"})
+	defer w.emit(events.Event{Op: "runPoaOperation", Msg: "completed"})
 
-	// Run the selection algorithm.
-	peer := w.selection()
-	w.evHandler("worker: runPoaOperation: SELECTED: %s", peer)
+	// Check which validator is expected to produce the next block.
+	w.emit(events.Event{Op: "runPoaOperation", Msg: fmt.Sprintf("selected validator[%s]", w.state.NextValidator())})
 
-	// If we are not selected, return and wait for the new block.
-	if peer != w.state.Host() {
+	// If it's not our turn, return and wait for the new block.
+	if !w.state.IsValidatorTurn() {
 		return
 	}
 
 	// Validate we are allowed to mine and we are not in a resync.
-	//if !w.state.IsMiningAllowed() {
-	//	w.evHandler("worker: runMiningOperation: MINING: turned off")
-	//	return
-	//}
+	if !w.state.IsMiningAllowed() {
+		w.emit(events.Event{Op: "runMiningOperation", Msg: "mining turned off"})
+		return
+	}
 
-	// Make sure there are transactions in the mempool.
-	length := w.state.MempoolLength()
+	// Make sure there are transactions in the mempool that can be mined.
+	length := w.state.MempoolPendingLength()
 	if length == 0 {
-		w.evHandler("worker: runMiningOperation: MINING: no transactions to mine: Txs[%d]", length)
+		w.emit(events.Event{Op: "runMiningOperation", Msg: "no transactions to mine"})
 		return
 	}
 
 	// Drain the cancel mining channel before starting.
 	select {
 	case <-w.cancelMining:
-		w.evHandler("worker: runMiningOperation: MINING: drained cancel channel")
+		w.emit(events.Event{Op: "runMiningOperation", Msg: "drained cancel channel"})
 	default:
 	}
 
@@ -99,7 +99,7 @@ func (w *Worker) runPoaOperation() {
 
 		select {
 		case <-w.cancelMining:
-			w.evHandler("worker: runMiningOperation: MINING: CANCEL: requested")
+			w.emit(events.Event{Op: "runMiningOperation", Msg: "cancel requested"})
 		case <-ctx.Done():
 		}
 	}()
@@ -115,24 +115,24 @@ func (w *Worker) runPoaOperation() {
 		block, err := w.state.MineNewBlock(ctx)
 		duration := time.Since(t)
 
-		w.evHandler("worker: runMiningOperation: MINING: mining duration[%v]", duration)
-
 		if err != nil {
 			switch {
 			case errors.Is(err, state.ErrNoTransactions):
-				w.evHandler("worker: runMiningOperation: MINING: WARNING: no transactions in mempool")
+				w.emit(events.Event{Kind: events.KindWarning, Op: "runMiningOperation", Msg: "no transactions in mempool", Duration: duration, Err: err})
 			case ctx.Err() != nil:
-				w.evHandler("worker: runMiningOperation: MINING: CANCEL: complete")
+				w.emit(events.Event{Kind: events.KindMiningCancelled, Op: "runMiningOperation", Msg: "cancel complete", Duration: duration})
 			default:
-				w.evHandler("worker: runMiningOperation: MINING: ERROR: %s", err)
+				w.emit(events.Event{Kind: events.KindError, Op: "runMiningOperation", Msg: "mining failed", Duration: duration, Err: err})
 			}
 			return
 		}
 
+		w.emit(events.Event{Kind: events.KindBlockMined, Op: "runMiningOperation", Msg: "block mined", BlockNumber: block.Header.Number, Duration: duration})
+
 		// The block is mined. Propose the new block to the network.
 		// Log the error, but that's it.
 		if err := w.state.NetSendBlockToPeers(block); err != nil {
-			w.evHandler("worker: runMiningOperation: MINING: proposeBlockToPeers: WARNING %s", err)
+			w.emit(events.Event{Kind: events.KindWarning, Op: "runMiningOperation", Msg: "propose block to peers", BlockNumber: block.Header.Number, Err: err})
 		}
 	}()
 
@@ -140,32 +140,6 @@ func (w *Worker) runPoaOperation() {
 	wg.Wait()
 }
 
-// selection selects a peer to be the next one to mine a block.
-func (w *Worker) selection() string {
-
-	// Retrive the know peers list which includes this node.
-	peers := w.state.KnownPeers()
-
-	// Just log information so we are clear what the list looks like.
-	w.evHandler("worker: runPoaOperation: selection: Host %s, List %v", w.state.Host(), peers)
-
-	// Sort the current list of peers by host.
-	names := make([]string, len(peers))
-	for i, peer := range peers {
-		names[i] = peer.Host
-	}
-	sort.Strings(names)
-
-	// Based on the latest block, pick an index number from the registry.
-	h := fnv.New32a()
-	h.Write([]byte(w.state.LatestBlock().Hash()))
-	integerHash := h.Sum32()
-	i := integerHash % uint32(len(names))
-
-	// Return the name of the node selected.
-	return names[i]
-}
-
 // =============================================================================
 
 // resetTicker makes sure the next tick happens on the described cadence.
@@ -3,9 +3,11 @@ package worker
 import (
 	"context"
 	"errors"
+	"fmt"
 	"sync"
 	"time"
 
+	"github.com/opplieam/bund-blockchain/internal/blockchain/events"
 	"github.com/opplieam/bund-blockchain/internal/blockchain/state"
 )
 
@@ -17,8 +19,8 @@ import (
 
 // powOperations handles mining.
 func (w *Worker) powOperations() {
-	w.evHandler("worker: powOperations: G started")
-	defer w.evHandler("worker: powOperations: G completed")
+	w.emit(events.Event{Op: "powOperations", Msg: "G started"})
+	defer w.emit(events.Event{Op: "powOperations", Msg: "G completed"})
 
 	for {
 		select {
@@ -27,7 +29,7 @@ func (w *Worker) powOperations() {
 				w.runPowOperation()
 			}
 		case <-w.shut:
-			w.evHandler("worker: powOperations: received shut signal")
+			w.emit(events.Event{Op: "powOperations", Msg: "received shut signal"})
 			return
 		}
 	}
@@ -36,22 +38,28 @@ func (w *Worker) powOperations() {
 // runPowOperation takes all the transactions from the mempool and writes a
 // new block to the database.
 func (w *Worker) runPowOperation() {
-	w.evHandler("worker: runMiningOperation: MINING: started")
-	defer w.evHandler("worker: runMiningOperation: MINING: completed")
+	w.emit(events.Event{Op: "runPowOperation", Msg: "started"})
+	defer w.emit(events.Event{Op: "runPowOperation", Msg: "completed"})
 
-	// Make sure there are transactions in the mempool.
-	length := w.state.MempoolLength()
+	// Validate we are allowed to mine and we are not in a resync.
+	if !w.state.IsMiningAllowed() {
+		w.emit(events.Event{Op: "runMiningOperation", Msg: "mining turned off"})
+		return
+	}
+
+	// Make sure there are transactions in the mempool that can be mined.
+	length := w.state.MempoolPendingLength()
 	if length == 0 {
-		w.evHandler("worker: runMiningOperation: MINING: no transactions to mine: Txs[%d]", length)
+		w.emit(events.Event{Op: "runMiningOperation", Msg: "no transactions to mine"})
 		return
 	}
 
 	// After running a mining operation, check if a new operation should
 	// be signaled again.
 	defer func() {
-		length := w.state.MempoolLength()
+		length := w.state.MempoolPendingLength()
 		if length > 0 {
-			w.evHandler("worker: runMiningOperation: MINING: signal new mining operation: Txs[%d]", length)
+			w.emit(events.Event{Op: "runMiningOperation", Msg: fmt.Sprintf("signal new mining operation: Txs[%d]", length)})
 			w.SignalStartMining()
 		}
 	}()
@@ -59,7 +67,7 @@ func (w *Worker) runPowOperation() {
 	// Drain the cancel mining channel before starting.
 	select {
 	case <-w.cancelMining:
-		w.evHandler("worker: runMiningOperation: MINING: drained cancel channel")
+		w.emit(events.Event{Op: "runMiningOperation", Msg: "drained cancel channel"})
 	default:
 	}
 
@@ -80,7 +88,7 @@ func (w *Worker) runPowOperation() {
 
 		select {
 		case <-w.cancelMining:
-			w.evHandler("worker: runMiningOperation: MINING: CANCEL: requested")
+			w.emit(events.Event{Op: "runMiningOperation", Msg: "cancel requested"})
 		case <-ctx.Done():
 		}
 	}()
@@ -96,24 +104,24 @@ func (w *Worker) runPowOperation() {
 		block, err := w.state.MineNewBlock(ctx)
 		duration := time.Since(t)
 
-		w.evHandler("worker: runMiningOperation: MINING: mining duration[%v]", duration)
-
 		if err != nil {
 			switch {
 			case errors.Is(err, state.ErrNoTransactions):
-				w.evHandler("worker: runMiningOperation: MINING: WARNING: no transactions in mempool")
+				w.emit(events.Event{Kind: events.KindWarning, Op: "runMiningOperation", Msg: "no transactions in mempool", Duration: duration, Err: err})
 			case ctx.Err() != nil:
-				w.evHandler("worker: runMiningOperation: MINING: CANCEL: complete")
+				w.emit(events.Event{Kind: events.KindMiningCancelled, Op: "runMiningOperation", Msg: "cancel complete", Duration: duration})
 			default:
-				w.evHandler("worker: runMiningOperation: MINING: ERROR: %s", err)
+				w.emit(events.Event{Kind: events.KindError, Op: "runMiningOperation", Msg: "mining failed", Duration: duration, Err: err})
 			}
 			return
 		}
 
+		w.emit(events.Event{Kind: events.KindBlockMined, Op: "runMiningOperation", Msg: "block mined", BlockNumber: block.Header.Number, Duration: duration})
+
 		// WOW, we mined a block. Propose the new block to the network.
 		// Log the error, but that's it.
 		if err := w.state.NetSendBlockToPeers(block); err != nil {
-			w.evHandler("worker: runMiningOperation: MINING: proposeBlockToPeers: WARNING %s", err)
+			w.emit(events.Event{Kind: events.KindWarning, Op: "runMiningOperation", Msg: "propose block to peers", BlockNumber: block.Header.Number, Err: err})
 		}
 	}()
 
@@ -0,0 +1,46 @@
+package worker
+
+import (
+	"errors"
+
+	"github.com/opplieam/bund-blockchain/internal/blockchain/events"
+	"github.com/opplieam/bund-blockchain/internal/blockchain/state"
+)
+
+// CORE NOTE: A block proposed by a peer that doesn't build on our chain means
+// we could be on the losing side of a fork. Finding the heaviest chain means
+// asking every peer for its status and replaying blocks, which takes too long
+// to do while the peer waits for an answer. The request to reorganize is
+// handed to this goroutine instead, and extra requests that come in while one
+// is pending are folded into it.
+
+// reorganizeOperations handles reorganizing the chain when a fork has been
+// identified.
+func (w *Worker) reorganizeOperations() {
+	w.emit(events.Event{Op: "reorganizeOperations", Msg: "G started"})
+	defer w.emit(events.Event{Op: "reorganizeOperations", Msg: "G completed"})
+
+	for {
+		select {
+		case <-w.reorganize:
+			if !w.isShutdown() {
+				w.runReorganizeOperation()
+			}
+		case <-w.shut:
+			w.emit(events.Event{Op: "reorganizeOperations", Msg: "received shut signal"})
+			return
+		}
+	}
+}
+
+// runReorganizeOperation replaces our chain with the heaviest chain known
+// by the peers.
+func (w *Worker) runReorganizeOperation() {
+	err := w.state.Reorganize()
+	switch {
+	case errors.Is(err, state.ErrNoBetterChain):
+		w.emit(events.Event{Op: "runReorganizeOperation", Msg: "no peer with a better chain"})
+	case err != nil:
+		w.emit(events.Event{Kind: events.KindError, Op: "runReorganizeOperation", Msg: "reorganize", Err: err})
+	}
+}
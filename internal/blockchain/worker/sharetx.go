@@ -1,5 +1,9 @@
 package worker
 
+import (
+	"github.com/opplieam/bund-blockchain/internal/blockchain/events"
+)
+
 // CORE NOTE: Sharing new transactions received directly by a wallet is
 // performed by this goroutine. When a wallet transaction is received,
 // the request goroutine shares it with this goroutine to send it over the
@@ -17,8 +21,8 @@ const maxTxShareRequests = 100
 
 // shareTxOperations handles sharing new block transactions.
 func (w *Worker) shareTxOperations() {
-	w.evHandler("worker: shareTxOperations: G started")
-	defer w.evHandler("worker: shareTxOperations: G completed")
+	w.emit(events.Event{Op: "shareTxOperations", Msg: "G started"})
+	defer w.emit(events.Event{Op: "shareTxOperations", Msg: "G completed"})
 
 	for {
 		select {
@@ -27,7 +31,7 @@ func (w *Worker) shareTxOperations() {
 				w.state.NetSendTxToPeers(tx)
 			}
 		case <-w.shut:
-			w.evHandler("worker: shareTxOperations: received shut signal")
+			w.emit(events.Event{Op: "shareTxOperations", Msg: "received shut signal"})
 			return
 		}
 	}
@@ -0,0 +1,39 @@
+package worker
+
+import (
+	"time"
+
+	"github.com/opplieam/bund-blockchain/internal/blockchain/events"
+)
+
+// CORE NOTE: Transactions that are never mined, like ones sent with a nonce
+// gap that is never filled, would otherwise sit in the mempool forever. This
+// goroutine periodically drops the transactions that are older than the
+// mempool TTL or have passed their own expiry. The mempool journal is then
+// compacted so it doesn't keep growing with transactions that have left the
+// mempool.
+
+// sweepOperations handles dropping expired transactions from the mempool
+// and compacting the mempool journal.
+func (w *Worker) sweepOperations() {
+	w.emit(events.Event{Op: "sweepOperations", Msg: "G started"})
+	defer w.emit(events.Event{Op: "sweepOperations", Msg: "G completed"})
+
+	ticker := time.NewTicker(mempoolSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if !w.isShutdown() {
+				w.state.SweepMempool()
+				if err := w.state.CompactMempoolJournal(); err != nil {
+					w.emit(events.Event{Kind: events.KindWarning, Op: "sweepOperations", Msg: "compact mempool journal", Err: err})
+				}
+			}
+		case <-w.shut:
+			w.emit(events.Event{Op: "sweepOperations", Msg: "received shut signal"})
+			return
+		}
+	}
+}
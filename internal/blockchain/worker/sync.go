@@ -1,5 +1,15 @@
 package worker
 
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/opplieam/bund-blockchain/internal/blockchain/database"
+	"github.com/opplieam/bund-blockchain/internal/blockchain/events"
+	"github.com/opplieam/bund-blockchain/internal/blockchain/peer"
+)
+
 // CORE NOTE: On startup or when reorganizing the chain, the node needs to be
 // in sync with the rest of the network. This includes the mempool and
 // blockchain database. This operation needs to finish before the node can
@@ -7,15 +17,19 @@ package worker
 
 // Sync updates the peer list, mempool and blocks.
 func (w *Worker) Sync() {
-	w.evHandler("worker: sync: started")
-	defer w.evHandler("worker: sync: completed")
+	w.emit(events.Event{Op: "Sync", Msg: "started"})
+	defer w.emit(events.Event{Op: "Sync", Msg: "completed"})
+
+	// Keep track of the peer whose chain holds the most work.
+	var best peer.Peer
+	var bestStatus peer.PeerStatus
 
 	for _, peer := range w.state.KnownExternalPeers() {
 
 		// Retrieve the status of this peer.
 		peerStatus, err := w.state.NetRequestPeerStatus(peer)
 		if err != nil {
-			w.evHandler("worker: sync: queryPeerStatus: %s: ERROR: %s", peer.Host, err)
+			w.emit(events.Event{Kind: events.KindError, Op: "Sync", Msg: "query peer status", Peer: peer.Host, Err: err})
 		}
 
 		// Add new peers to this nodes list.
@@ -24,23 +38,49 @@ func (w *Worker) Sync() {
 		// Retrieve the mempool from the peer.
 		pool, err := w.state.NetRequestPeerMempool(peer)
 		if err != nil {
-			w.evHandler("worker: sync: retrievePeerMempool: %s: ERROR: %s", peer.Host, err)
+			w.emit(events.Event{Kind: events.KindError, Op: "Sync", Msg: "retrieve peer mempool", Peer: peer.Host, Err: err})
 		}
 		for _, tx := range pool {
-			w.evHandler("worker: sync: retrievePeerMempool: %s: Add Tx: %s", peer.Host, tx.SignatureString()[:16])
+			w.emit(events.Event{Op: "Sync", Msg: "add tx from peer mempool", TxKey: tx.String(), Peer: peer.Host})
 			w.state.UpsertMempool(tx)
 		}
 
-		// If this peer has blocks we don't have, we need to add them.
-		if peerStatus.LatestBlockNumber > w.state.LatestBlock().Header.Number {
-			w.evHandler("worker: sync: retrievePeerBlocks: %s: latestBlockNumber[%d]", peer.Host, peerStatus.LatestBlockNumber)
-
-			if err := w.state.NetRequestPeerBlocks(peer); err != nil {
-				w.evHandler("worker: sync: retrievePeerBlocks: %s: ERROR %s", peer.Host, err)
-			}
+		if peerStatus.TotalWork != nil && (bestStatus.TotalWork == nil || peerStatus.TotalWork.Cmp(bestStatus.TotalWork) > 0) {
+			best = peer
+			bestStatus = peerStatus
 		}
 	}
 
+	// If the best peer has a chain with more work than ours, we need to
+	// take their blocks.
+	if bestStatus.TotalWork != nil && bestStatus.TotalWork.Cmp(w.state.TotalWork()) > 0 {
+		w.syncBlocks(best, bestStatus.TotalWork)
+	}
+
 	// Share with peers this node is available to participate in the network.
 	w.state.NetSendNodeAvailableToPeers()
 }
+
+// syncBlocks retrieves the blocks we are missing from the specified peer. If
+// the peer turns out to be on a different branch, the chain is reorganized.
+func (w *Worker) syncBlocks(pr peer.Peer, peerWork *big.Int) {
+	w.emit(events.Event{Op: "syncBlocks", Msg: fmt.Sprintf("totalWork[%s]", peerWork), Peer: pr.Host})
+
+	err := w.state.NetRequestPeerBlocks(pr)
+	if err != nil {
+		w.emit(events.Event{Kind: events.KindError, Op: "syncBlocks", Msg: "retrieve peer blocks", Peer: pr.Host, Err: err})
+	}
+
+	// Adding the missing blocks is enough when the peer's chain extends ours.
+	if err == nil && w.state.TotalWork().Cmp(peerWork) >= 0 {
+		return
+	}
+
+	// The peer is on a different branch of the chain. Roll back to the
+	// common ancestor and take the branch with the most work.
+	if err == nil || errors.Is(err, database.ErrChainForked) {
+		if err := w.state.ReorganizeFromPeer(pr); err != nil {
+			w.emit(events.Event{Kind: events.KindError, Op: "syncBlocks", Msg: "reorganize", Peer: pr.Host, Err: err})
+		}
+	}
+}
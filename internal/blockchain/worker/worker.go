@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/opplieam/bund-blockchain/internal/blockchain/database"
+	"github.com/opplieam/bund-blockchain/internal/blockchain/events"
 	"github.com/opplieam/bund-blockchain/internal/blockchain/state"
 )
 
@@ -14,6 +15,10 @@ import (
 // and updating the blockchain on disk with missing blocks.
 const peerUpdateInterval = time.Second * 10
 
+// mempoolSweepInterval represents the interval of dropping expired
+// transactions from the mempool.
+const mempoolSweepInterval = time.Minute
+
 // Worker manages the POW workflows for the blockchain.
 type Worker struct {
 	state        *state.State
@@ -22,21 +27,24 @@ type Worker struct {
 	shut         chan struct{}
 	startMining  chan bool
 	cancelMining chan bool
+	reorganize   chan bool
 	txSharing    chan database.BlockTx
-	evHandler    state.EventHandler
+	bus          *events.Bus
 }
 
 // Run creates a worker, registers the worker with the state package, and
-// starts up all the background processes.
-func Run(st *state.State, evHandler state.EventHandler) {
+// starts up all the background processes. Events are published on the bus
+// used by the state.
+func Run(st *state.State) {
 	w := Worker{
 		state:        st,
 		ticker:       *time.NewTicker(peerUpdateInterval),
 		shut:         make(chan struct{}),
 		startMining:  make(chan bool, 1),
 		cancelMining: make(chan bool, 1),
+		reorganize:   make(chan bool, 1),
 		txSharing:    make(chan database.BlockTx, maxTxShareRequests),
-		evHandler:    evHandler,
+		bus:          st.Events(),
 	}
 	// Register this worker with the state package.
 	st.Worker = &w
@@ -44,6 +52,12 @@ func Run(st *state.State, evHandler state.EventHandler) {
 	// Update this node before starting any support G's.
 	w.Sync()
 
+	// The mempool can hold transactions from the journal or the peers that
+	// are ready to be mined.
+	if w.state.MempoolPendingLength() > 0 {
+		w.SignalStartMining()
+	}
+
 	// Select the consensus operation to run.
 	consensusOperation := w.powOperations
 	if st.Consensus() == state.ConsensusPOA {
@@ -54,6 +68,8 @@ func Run(st *state.State, evHandler state.EventHandler) {
 	operations := []func(){
 		w.peerOperations,
 		w.shareTxOperations,
+		w.sweepOperations,
+		w.reorganizeOperations,
 		consensusOperation,
 	}
 
@@ -86,13 +102,13 @@ func Run(st *state.State, evHandler state.EventHandler) {
 
 // Shutdown terminates the goroutine performing work.
 func (w *Worker) Shutdown() {
-	w.evHandler("worker: shutdown: started")
-	defer w.evHandler("worker: shutdown: completed")
+	w.emit(events.Event{Op: "Shutdown", Msg: "started"})
+	defer w.emit(events.Event{Op: "Shutdown", Msg: "completed"})
 
-	w.evHandler("worker: shutdown: signal cancel mining")
+	w.emit(events.Event{Op: "Shutdown", Msg: "signal cancel mining"})
 	w.SignalCancelMining()
 
-	w.evHandler("worker: shutdown: terminate goroutines")
+	w.emit(events.Event{Op: "Shutdown", Msg: "terminate goroutines"})
 	close(w.shut)
 	w.wg.Wait()
 }
@@ -105,7 +121,7 @@ func (w *Worker) SignalStartMining() {
 	case w.startMining <- true:
 	default:
 	}
-	w.evHandler("worker: SignalStartMining: mining signaled")
+	w.emit(events.Event{Op: "SignalStartMining", Msg: "mining signaled"})
 }
 
 // SignalCancelMining signals the G executing the runMiningOperation function
@@ -116,7 +132,18 @@ func (w *Worker) SignalCancelMining() {
 	case w.cancelMining <- true:
 	default:
 	}
-	w.evHandler("worker: SignalCancelMining: MINING: CANCEL: signaled")
+	w.emit(events.Event{Op: "SignalCancelMining", Msg: "cancel signaled"})
+}
+
+// SignalReorganize starts a reorganization of the chain. If there is already
+// a signal pending in the channel, just return since a reorganization will
+// start.
+func (w *Worker) SignalReorganize() {
+	select {
+	case w.reorganize <- true:
+	default:
+	}
+	w.emit(events.Event{Op: "SignalReorganize", Msg: "reorganize signaled"})
 }
 
 // SignalShareTx signals a share transaction operation. If
@@ -124,14 +151,20 @@ func (w *Worker) SignalCancelMining() {
 func (w *Worker) SignalShareTx(blockTx database.BlockTx) {
 	select {
 	case w.txSharing <- blockTx:
-		w.evHandler("worker: SignalShareTx: share Tx signaled")
+		w.emit(events.Event{Op: "SignalShareTx", Msg: "share tx signaled", TxKey: blockTx.String()})
 	default:
-		w.evHandler("worker: SignalShareTx: queue full, transactions won't be shared.")
+		w.emit(events.Event{Kind: events.KindWarning, Op: "SignalShareTx", Msg: "queue full, transactions won't be shared", TxKey: blockTx.String()})
 	}
 }
 
 // =============================================================================
 
+// emit publishes the event on behalf of the worker.
+func (w *Worker) emit(ev events.Event) {
+	ev.Subsystem = "worker"
+	w.bus.Publish(ev)
+}
+
 // isShutdown is used to test if a shutdown has been signaled.
 func (w *Worker) isShutdown() bool {
 	select {
@@ -1,13 +1,16 @@
 package handler
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"strconv"
 
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/labstack/echo/v4"
 	"github.com/opplieam/bund-blockchain/internal/blockchain/database"
+	"github.com/opplieam/bund-blockchain/internal/blockchain/mempool"
 	"github.com/opplieam/bund-blockchain/internal/blockchain/peer"
 	"github.com/opplieam/bund-blockchain/internal/blockchain/state"
 	"github.com/opplieam/bund-blockchain/internal/nameservice"
@@ -17,6 +20,10 @@ type Handler struct {
 	Log   *slog.Logger
 	State *state.State
 	NS    *nameservice.NameService
+
+	// Origins of the browser pages allowed to open a WebSocket, "*" allows
+	// any origin.
+	WSOrigins []string
 }
 
 func New(logger *slog.Logger, state *state.State, ns *nameservice.NameService) *Handler {
@@ -32,14 +39,58 @@ func (h *Handler) Genesis(c echo.Context) error {
 	return c.JSON(http.StatusOK, h.State.Genesis())
 }
 
+// Supply returns the coins in existence as of the latest block.
+func (h *Handler) Supply(c echo.Context) error {
+	return c.JSON(http.StatusOK, h.State.Supply())
+}
+
 // Accounts returns the current balances for all users.
 func (h *Handler) Accounts(c echo.Context) error {
 	accountStr := c.Param("account")
+	blockStr := c.QueryParam("block")
 
 	var accounts map[database.AccountID]database.Account
-	switch accountStr {
-	case "":
+	var block *actBlock
+	switch {
+	case accountStr == "" && blockStr != "":
+		return c.String(http.StatusBadRequest, "block can only be used with an account")
+
+	case accountStr == "":
 		accounts = h.State.Accounts()
+
+	case blockStr != "":
+		accountID, err := database.ToAccountID(accountStr)
+		if err != nil {
+			return c.String(http.StatusBadRequest, err.Error())
+		}
+		num, err := strconv.ParseUint(blockStr, 10, 64)
+		if err != nil {
+			return c.String(http.StatusBadRequest, err.Error())
+		}
+
+		account, blk, err := h.State.QueryAccountAtBlock(accountID, num)
+		if err != nil {
+			if errors.Is(err, database.ErrNotFound) {
+				return c.String(http.StatusNotFound, "block not found")
+			}
+			return c.String(http.StatusBadRequest, err.Error())
+		}
+		accounts = map[database.AccountID]database.Account{accountID: account}
+
+		// The account is as it was after the block, so the state root is
+		// the hash of the accounts after the block was applied, not the
+		// one in the block header.
+		stateRoot, err := h.State.StateRootAfter(num)
+		if err != nil {
+			return c.String(http.StatusNotFound, "block not found")
+		}
+
+		block = &actBlock{
+			Number:    blk.Header.Number,
+			Hash:      blk.Hash(),
+			StateRoot: stateRoot,
+		}
+
 	default:
 		accountID, err := database.ToAccountID(accountStr)
 		if err != nil {
@@ -67,11 +118,72 @@ func (h *Handler) Accounts(c echo.Context) error {
 		LastestBlock: h.State.LatestBlock().Hash(),
 		Uncommitted:  len(h.State.Mempool()),
 		Accounts:     resp,
+		Block:        block,
 	}
 
 	return c.JSON(200, ai)
 }
 
+// AccountTransactions returns a page of the mined transactions that involved
+// the specified account. The direction query parameter filters the
+// transactions to the ones sent or received by the account.
+func (h *Handler) AccountTransactions(c echo.Context) error {
+	accountID, err := database.ToAccountID(c.Param("account"))
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+
+	direction := c.QueryParam("direction")
+	if direction == "" {
+		direction = database.DirectionAll
+	}
+
+	page, rows := 1, 20
+	if str := c.QueryParam("page"); str != "" {
+		if page, err = strconv.Atoi(str); err != nil || page < 1 {
+			return c.String(http.StatusBadRequest, "page must be a positive number")
+		}
+	}
+	if str := c.QueryParam("rows"); str != "" {
+		if rows, err = strconv.Atoi(str); err != nil || rows < 1 || rows > 100 {
+			return c.String(http.StatusBadRequest, "rows must be between 1 and 100")
+		}
+	}
+
+	results, err := h.State.QueryAccountTxs(accountID, direction, (page-1)*rows, rows)
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+
+	txs := make([]accountTx, len(results))
+	for i, result := range results {
+		dir := database.DirectionSent
+		if !result.Sent {
+			dir = database.DirectionReceived
+		}
+
+		txs[i] = accountTx{
+			BlockNumber: result.BlockNumber,
+			Index:       result.Index,
+			Direction:   dir,
+			Tx:          h.toTx(result.Tx),
+		}
+		if result.Receipt != nil {
+			txs[i].Status = result.Receipt.Status
+		}
+	}
+
+	resp := accountTxs{
+		Account:   accountID,
+		Direction: direction,
+		Page:      page,
+		Rows:      rows,
+		Txs:       txs,
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
 // Mempool returns the set of uncommitted transactions.
 func (h *Handler) Mempool(c echo.Context) error {
 	accountStr := c.Param("account")
@@ -83,46 +195,126 @@ func (h *Handler) Mempool(c echo.Context) error {
 			continue
 		}
 
-		txResult = append(txResult, tx{
-			FromAccount: tran.FromID,
-			FromName:    h.NS.Lookup(tran.FromID),
-			To:          tran.ToID,
-			ToName:      h.NS.Lookup(tran.ToID),
-			ChainID:     tran.ChainID,
-			Nonce:       tran.Nonce,
-			Value:       tran.Value,
-			Tip:         tran.Tip,
-			Data:        tran.Data,
-			TimeStamp:   tran.TimeStamp,
-			GasPrice:    tran.GasPrice,
-			GasUnits:    tran.GasUnits,
-			Sig:         tran.SignatureString(),
-		})
+		txResult = append(txResult, h.toTx(tran))
 	}
 
 	return c.JSON(http.StatusOK, txResult)
 }
 
+// BlockByHash returns the block with the specified hash along with its
+// inclusion status.
+func (h *Handler) BlockByHash(c echo.Context) error {
+	result, err := h.State.QueryBlockByHash(c.Param("hash"))
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return c.String(http.StatusNotFound, "block not found")
+		}
+		return err
+	}
+
+	info := blockInfo{
+		Status:        result.Status,
+		Confirmations: result.Confirmations,
+		Block:         database.NewBlockData(result.Block),
+	}
+
+	return c.JSON(http.StatusOK, info)
+}
+
+// TransactionByHash returns the transaction with the specified hash along
+// with its inclusion status.
+func (h *Handler) TransactionByHash(c echo.Context) error {
+	result, err := h.State.QueryTransaction(c.Param("hash"))
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return c.String(http.StatusNotFound, "transaction not found")
+		}
+		return err
+	}
+
+	info := txInfo{
+		Status:        result.Status,
+		BlockNumber:   result.BlockNumber,
+		BlockHash:     result.BlockHash,
+		Index:         result.Index,
+		Confirmations: result.Confirmations,
+		Tx:            h.toTx(result.Tx),
+	}
+
+	return c.JSON(http.StatusOK, info)
+}
+
+// TransactionReceipt returns the receipt for the mined transaction with the
+// specified hash.
+func (h *Handler) TransactionReceipt(c echo.Context) error {
+	receipt, err := h.State.QueryReceipt(c.Param("hash"))
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return c.String(http.StatusNotFound, "receipt not found")
+		}
+		return err
+	}
+
+	return c.JSON(http.StatusOK, receipt)
+}
+
+// TransactionProof returns the merkle proof that the transaction with the
+// specified hash is part of the specified block.
+func (h *Handler) TransactionProof(c echo.Context) error {
+	blockNum, err := strconv.ParseUint(c.Param("block"), 10, 64)
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+
+	result, err := h.State.QueryTxProof(blockNum, c.Param("hash"))
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return c.String(http.StatusNotFound, "transaction not found in block")
+		}
+		return err
+	}
+
+	proof := make([]string, len(result.Proof))
+	for i, hash := range result.Proof {
+		proof[i] = hexutil.Encode(hash)
+	}
+
+	tp := txProof{
+		BlockHash: result.Block.Hash(),
+		Header:    result.Block.Header,
+		Tx:        result.Tx,
+		Proof:     proof,
+		Order:     result.Order,
+	}
+
+	return c.JSON(http.StatusOK, tp)
+}
+
 // SubmitWalletTransaction adds new transactions to the mempool.
 func (h *Handler) SubmitWalletTransaction(c echo.Context) error {
 	var signedTx database.SignedTx
 	if err := c.Bind(&signedTx); err != nil {
-		return c.String(http.StatusBadRequest, err.Error())
+		return c.JSON(http.StatusBadRequest, errorResponse{Code: codeInvalidRequest, Error: err.Error()})
 	}
 	h.Log.Info("add trans", "sig|nonce", signedTx, "from", signedTx.FromID, "to", signedTx.ToID, "value", signedTx.Value, "tip", signedTx.Tip)
 
 	// Ask the state package to add this transaction to the mempool. Only the
-	// checks are the transaction signature and the recipient account format.
-	// It's up to the wallet to make sure the account has a proper balance and
-	// nonce. Fees will be taken if this transaction is mined into a block.
+	// checks are the transaction signature and the recipient account format,
+	// unless strict admission also checks the nonce isn't already used and
+	// the balance. A transaction with a nonce after a gap is queued until the
+	// missing nonce is submitted. Fees will be taken if this transaction is
+	// mined into a block.
 	if err := h.State.UpsertWalletTransaction(signedTx); err != nil {
-		return c.String(http.StatusBadRequest, err.Error())
+		status, code := submitErrorCode(err)
+		return c.JSON(status, errorResponse{Code: code, Error: err.Error()})
 	}
 
 	response := struct {
 		Status string `json:"status"`
+		Hash   string `json:"hash"`
 	}{
 		Status: "transactions added to mempool",
+		Hash:   signedTx.TxHash(),
 	}
 	return c.JSON(http.StatusOK, response)
 }
@@ -138,6 +330,7 @@ func (h *Handler) Status(c echo.Context) error {
 	status := peer.PeerStatus{
 		LatestBlockHash:   latestBlock.Hash(),
 		LatestBlockNumber: latestBlock.Header.Number,
+		TotalWork:         h.State.TotalWork(),
 		KnownPeers:        h.State.KnownExternalPeers(),
 	}
 	return c.JSON(http.StatusOK, status)
@@ -226,24 +419,28 @@ func (h *Handler) ProposeBlock(c echo.Context) error {
 	// Decode the JSON in the post call into a file system block.
 	var blockData database.BlockData
 	if err := c.Bind(&blockData); err != nil {
-		return c.String(http.StatusBadRequest, err.Error())
+		return c.JSON(http.StatusBadRequest, errorResponse{Code: codeInvalidRequest, Error: err.Error()})
 	}
 
 	// Convert the block data into a block. This action will create a merkle
 	// tree for the set of transactions required for blockchain operations.
 	block, err := database.ToBlock(blockData)
 	if err != nil {
-		return fmt.Errorf("unable to decode block: %w", err)
+		return c.JSON(http.StatusBadRequest, errorResponse{Code: codeInvalidRequest, Error: fmt.Sprintf("unable to decode block: %s", err)})
 	}
 
 	// Ask the state package to validate the proposed block. If the block
 	// passes validation, it will be added to the blockchain database.
 	if err := h.State.ProcessProposedBlock(block); err != nil {
-		//if errors.Is(err, database.ErrChainForked) {
-		//	h.State.Reorganize()
-		//}
+		// The reorganization talks to the peers and replays blocks, so it
+		// runs in the background instead of holding up the peer.
+		if errors.Is(err, database.ErrChainForked) {
+			h.State.Worker.SignalReorganize()
+		}
 
-		return c.String(http.StatusBadRequest, "block not accepted")
+		// Tell the peer why the block was rejected.
+		status, code := proposeErrorCode(err)
+		return c.JSON(status, errorResponse{Code: code, Error: err.Error()})
 	}
 
 	resp := struct {
@@ -254,3 +451,104 @@ func (h *Handler) ProposeBlock(c echo.Context) error {
 
 	return c.JSON(http.StatusOK, resp)
 }
+
+// toTx converts a block transaction into the model returned by the API.
+func (h *Handler) toTx(tran database.BlockTx) tx {
+	return tx{
+		Hash:        tran.TxHash(),
+		FromAccount: tran.FromID,
+		FromName:    h.NS.Lookup(tran.FromID),
+		To:          tran.ToID,
+		ToName:      h.NS.Lookup(tran.ToID),
+		ChainID:     tran.ChainID,
+		Nonce:       tran.Nonce,
+		Value:       tran.Value,
+		Tip:         tran.Tip,
+		Data:        tran.Data,
+		ExpiryBlock: tran.ExpiryBlock,
+		ExpiryTime:  tran.ExpiryTime,
+		TimeStamp:   tran.TimeStamp,
+		GasPrice:    tran.GasPrice,
+		GasUnits:    tran.GasUnits,
+		Sig:         tran.SignatureString(),
+	}
+}
+
+// Set of error codes returned when a transaction is rejected, so a wallet
+// can tell the failures apart without parsing the message.
+const (
+	codeInvalidRequest     = "invalid_request"
+	codeInvalidTransaction = "invalid_transaction"
+	codeNonceTooLow        = "nonce_too_low"
+	codeExpired            = "expired"
+	codeInsufficientFunds  = "insufficient_funds"
+	codeUnderpriced        = "replacement_underpriced"
+	codeAccountLimit       = "account_limit"
+	codeMempoolFull        = "mempool_full"
+)
+
+// submitErrorCode maps the reason a transaction was rejected to the HTTP
+// status and error code returned to the wallet.
+func submitErrorCode(err error) (int, string) {
+	switch {
+	case errors.Is(err, mempool.ErrNonceTooLow):
+		return http.StatusConflict, codeNonceTooLow
+	case errors.Is(err, database.ErrTxExpired):
+		return http.StatusBadRequest, codeExpired
+	case errors.Is(err, state.ErrInsufficientFunds):
+		return http.StatusPaymentRequired, codeInsufficientFunds
+	case errors.Is(err, mempool.ErrUnderpriced):
+		return http.StatusConflict, codeUnderpriced
+	case errors.Is(err, mempool.ErrAccountLimit):
+		return http.StatusTooManyRequests, codeAccountLimit
+	case errors.Is(err, mempool.ErrMempoolFull):
+		return http.StatusServiceUnavailable, codeMempoolFull
+	}
+
+	return http.StatusBadRequest, codeInvalidTransaction
+}
+
+// Set of error codes returned when a proposed block is rejected, identifying
+// the validation check that failed.
+const (
+	codeChainForked         = "chain_forked"
+	codeInvalidBlock        = "invalid_block"
+	codeInvalidDifficulty   = "invalid_difficulty"
+	codeInvalidHash         = "invalid_hash"
+	codeInvalidNumber       = "invalid_number"
+	codeInvalidTimestamp    = "invalid_timestamp"
+	codeInvalidMerkleRoot   = "invalid_merkle_root"
+	codeInvalidStateRoot    = "invalid_state_root"
+	codeInvalidSigner       = "invalid_signer"
+	codeInvalidMiningReward = "invalid_mining_reward"
+	codeTxExpired           = "tx_expired"
+)
+
+// proposeErrorCode maps the reason a proposed block was rejected to the HTTP
+// status and error code returned to the peer.
+func proposeErrorCode(err error) (int, string) {
+	switch {
+	case errors.Is(err, database.ErrChainForked):
+		return http.StatusConflict, codeChainForked
+	case errors.Is(err, database.ErrInvalidDifficulty):
+		return http.StatusBadRequest, codeInvalidDifficulty
+	case errors.Is(err, database.ErrInvalidHash):
+		return http.StatusBadRequest, codeInvalidHash
+	case errors.Is(err, database.ErrInvalidNumber):
+		return http.StatusBadRequest, codeInvalidNumber
+	case errors.Is(err, database.ErrInvalidTimestamp):
+		return http.StatusBadRequest, codeInvalidTimestamp
+	case errors.Is(err, database.ErrInvalidMerkleRoot):
+		return http.StatusBadRequest, codeInvalidMerkleRoot
+	case errors.Is(err, database.ErrInvalidStateRoot):
+		return http.StatusBadRequest, codeInvalidStateRoot
+	case errors.Is(err, database.ErrInvalidSigner):
+		return http.StatusBadRequest, codeInvalidSigner
+	case errors.Is(err, database.ErrInvalidMiningReward):
+		return http.StatusBadRequest, codeInvalidMiningReward
+	case errors.Is(err, database.ErrTxExpired):
+		return http.StatusBadRequest, codeTxExpired
+	}
+
+	return http.StatusBadRequest, codeInvalidBlock
+}
@@ -12,12 +12,20 @@ type act struct {
 }
 
 type actInfo struct {
-	LastestBlock string `json:"lastest_block"`
-	Uncommitted  int    `json:"uncommitted"`
-	Accounts     []act  `json:"accounts"`
+	LastestBlock string    `json:"lastest_block"`
+	Uncommitted  int       `json:"uncommitted"`
+	Accounts     []act     `json:"accounts"`
+	Block        *actBlock `json:"block,omitempty"`
+}
+
+type actBlock struct {
+	Number    uint64 `json:"number"`
+	Hash      string `json:"hash"`
+	StateRoot string `json:"state_root"`
 }
 
 type tx struct {
+	Hash        string             `json:"hash"`
 	FromAccount database.AccountID `json:"from"`
 	FromName    string             `json:"from_name"`
 	To          database.AccountID `json:"to"`
@@ -27,8 +35,61 @@ type tx struct {
 	Value       uint64             `json:"value"`
 	Tip         uint64             `json:"tip"`
 	Data        []byte             `json:"data"`
+	ExpiryBlock uint64             `json:"expiry_block,omitempty"`
+	ExpiryTime  uint64             `json:"expiry_time,omitempty"`
 	TimeStamp   uint64             `json:"timestamp"`
 	GasPrice    uint64             `json:"gas_price"`
 	GasUnits    uint64             `json:"gas_units"`
 	Sig         string             `json:"sig"`
 }
+
+type blockInfo struct {
+	Status        string             `json:"status"`
+	Confirmations uint64             `json:"confirmations"`
+	Block         database.BlockData `json:"block"`
+}
+
+type txInfo struct {
+	Status        string `json:"status"`
+	BlockNumber   uint64 `json:"block_number,omitempty"`
+	BlockHash     string `json:"block_hash,omitempty"`
+	Index         int    `json:"index"`
+	Confirmations uint64 `json:"confirmations"`
+	Tx            tx     `json:"tx"`
+}
+
+type txProof struct {
+	BlockHash string               `json:"block_hash"`
+	Header    database.BlockHeader `json:"header"`
+	Tx        database.BlockTx     `json:"tx"`
+	Proof     []string             `json:"proof"`
+	Order     []int64              `json:"order"`
+}
+
+type accountTx struct {
+	BlockNumber uint64 `json:"block_number"`
+	Index       int    `json:"index"`
+	Direction   string `json:"direction"`
+	Status      string `json:"status,omitempty"`
+	Tx          tx     `json:"tx"`
+}
+
+type accountTxs struct {
+	Account   database.AccountID `json:"account"`
+	Direction string             `json:"direction"`
+	Page      int                `json:"page"`
+	Rows      int                `json:"rows"`
+	Txs       []accountTx        `json:"txs"`
+}
+
+type feedMsg struct {
+	Topic   string              `json:"topic"`
+	Block   *database.BlockData `json:"block,omitempty"`
+	Tx      *tx                 `json:"tx,omitempty"`
+	Account *act                `json:"account,omitempty"`
+}
+
+type errorResponse struct {
+	Code  string `json:"code"`
+	Error string `json:"error"`
+}
@@ -0,0 +1,380 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/labstack/echo/v4"
+	"github.com/opplieam/bund-blockchain/internal/blockchain/database"
+)
+
+// CORE NOTE: The JSON-RPC endpoint lets tooling built for Ethereum talk to
+// the node. Only the subset of the eth namespace that maps onto our chain is
+// implemented. Quantities are hex encoded like Ethereum does, and a raw
+// transaction is a Bund signed transaction, either as a JSON object or as
+// the hex encoding of that JSON.
+
+// Standard JSON-RPC 2.0 error codes.
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcInternalError  = -32603
+	rpcServerError    = -32000
+)
+
+// rpcRequest represents a JSON-RPC 2.0 request.
+type rpcRequest struct {
+	JSONRPC string            `json:"jsonrpc"`
+	Method  string            `json:"method"`
+	Params  []json.RawMessage `json:"params"`
+	ID      json.RawMessage   `json:"id"`
+}
+
+// rpcResponse represents a JSON-RPC 2.0 response.
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+// rpcError represents a JSON-RPC 2.0 error.
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// Error implements the error interface.
+func (e *rpcError) Error() string {
+	return e.Message
+}
+
+// RPC handles JSON-RPC 2.0 requests, including batches of requests.
+func (h *Handler) RPC(c echo.Context) error {
+	body, err := io.ReadAll(c.Request().Body)
+	if err != nil {
+		return c.JSON(http.StatusOK, rpcFailure(nil, rpcParseError, err.Error()))
+	}
+	body = bytes.TrimSpace(body)
+
+	// A single request.
+	if len(body) == 0 || body[0] != '[' {
+		resp, ok := h.rpcCall(body)
+		if !ok {
+			return c.NoContent(http.StatusNoContent)
+		}
+		return c.JSON(http.StatusOK, resp)
+	}
+
+	// A batch of requests.
+	var batch []json.RawMessage
+	if err := json.Unmarshal(body, &batch); err != nil {
+		return c.JSON(http.StatusOK, rpcFailure(nil, rpcParseError, err.Error()))
+	}
+	if len(batch) == 0 {
+		return c.JSON(http.StatusOK, rpcFailure(nil, rpcInvalidRequest, "empty batch"))
+	}
+
+	resps := make([]rpcResponse, 0, len(batch))
+	for _, raw := range batch {
+		if resp, ok := h.rpcCall(raw); ok {
+			resps = append(resps, resp)
+		}
+	}
+
+	// A batch of notifications doesn't get a response.
+	if len(resps) == 0 {
+		return c.NoContent(http.StatusNoContent)
+	}
+
+	return c.JSON(http.StatusOK, resps)
+}
+
+// =============================================================================
+
+// rpcCall decodes and executes a single request. False is returned when the
+// request is a notification and doesn't get a response.
+func (h *Handler) rpcCall(raw json.RawMessage) (rpcResponse, bool) {
+	var req rpcRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			return rpcFailure(nil, rpcParseError, err.Error()), true
+		}
+		return rpcFailure(nil, rpcInvalidRequest, err.Error()), true
+	}
+
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		return rpcFailure(req.ID, rpcInvalidRequest, "invalid request"), true
+	}
+
+	result, err := h.rpcDispatch(req)
+
+	// Notifications don't have an id and don't get a response.
+	if req.ID == nil {
+		return rpcResponse{}, false
+	}
+
+	if err != nil {
+		var rpcErr *rpcError
+		if errors.As(err, &rpcErr) {
+			return rpcFailure(req.ID, rpcErr.Code, rpcErr.Message), true
+		}
+		return rpcFailure(req.ID, rpcInternalError, err.Error()), true
+	}
+
+	resp := rpcResponse{
+		JSONRPC: "2.0",
+		Result:  result,
+		ID:      req.ID,
+	}
+
+	// A null result still needs to be present in the response.
+	if result == nil {
+		resp.Result = json.RawMessage("null")
+	}
+
+	return resp, true
+}
+
+// rpcDispatch executes the method for the request.
+func (h *Handler) rpcDispatch(req rpcRequest) (any, error) {
+	switch req.Method {
+	case "eth_chainId":
+		return hexutil.EncodeUint64(uint64(h.State.Genesis().ChainID)), nil
+
+	case "eth_blockNumber":
+		return hexutil.EncodeUint64(h.State.LatestBlock().Header.Number), nil
+
+	case "eth_getBalance", "eth_getTransactionCount":
+		var address string
+		if err := rpcParams(req.Params, 1, &address); err != nil {
+			return nil, err
+		}
+		account, err := h.rpcAccount(address, req.Params)
+		if err != nil {
+			return nil, err
+		}
+		if req.Method == "eth_getBalance" {
+			return hexutil.EncodeUint64(account.Balance), nil
+		}
+		return hexutil.EncodeUint64(account.Nonce), nil
+
+	case "eth_getBlockByNumber":
+		var tag string
+		var full bool
+		if err := rpcParams(req.Params, 1, &tag, &full); err != nil {
+			return nil, err
+		}
+		num, err := h.rpcBlockNumber(tag)
+		if err != nil {
+			return nil, err
+		}
+		if num == 0 {
+			return nil, nil
+		}
+		blocks := h.State.QueryBlocksByNumber(num, num)
+		if len(blocks) == 0 {
+			return nil, nil
+		}
+		return h.rpcBlock(blocks[0], full), nil
+
+	case "eth_getBlockByHash":
+		var hash string
+		var full bool
+		if err := rpcParams(req.Params, 1, &hash, &full); err != nil {
+			return nil, err
+		}
+		result, err := h.State.QueryBlockByHash(hash)
+		if err != nil {
+			if errors.Is(err, database.ErrNotFound) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		return h.rpcBlock(result.Block, full), nil
+
+	case "eth_sendRawTransaction":
+		if len(req.Params) < 1 {
+			return nil, &rpcError{Code: rpcInvalidParams, Message: "missing transaction"}
+		}
+		signedTx, err := rpcSignedTx(req.Params[0])
+		if err != nil {
+			return nil, &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+		}
+		if err := h.State.UpsertWalletTransaction(signedTx); err != nil {
+			return nil, &rpcError{Code: rpcServerError, Message: err.Error()}
+		}
+		return signedTx.TxHash(), nil
+	}
+
+	return nil, &rpcError{Code: rpcMethodNotFound, Message: fmt.Sprintf("method %s not found", req.Method)}
+}
+
+// rpcAccount returns the account for the address at the block specified by
+// the second parameter. An account that doesn't exist has a zero balance.
+func (h *Handler) rpcAccount(address string, params []json.RawMessage) (database.Account, error) {
+	accountID, err := database.ToAccountID(address)
+	if err != nil {
+		return database.Account{}, &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+	}
+
+	tag := "latest"
+	if len(params) > 1 {
+		if err := json.Unmarshal(params[1], &tag); err != nil {
+			return database.Account{}, &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+		}
+	}
+
+	var account database.Account
+	switch tag {
+	case "latest", "pending", "safe", "finalized":
+		account, err = h.State.QueryAccount(accountID)
+
+	default:
+		num, numErr := h.rpcBlockNumber(tag)
+		if numErr != nil {
+			return database.Account{}, numErr
+		}
+
+		account, _, err = h.State.QueryAccountAtBlock(accountID, num)
+		if errors.Is(err, database.ErrNotFound) {
+			return database.Account{}, &rpcError{Code: rpcInvalidParams, Message: fmt.Sprintf("block %d not found", num)}
+		}
+	}
+
+	switch {
+	case errors.Is(err, database.ErrAccountNotFound):
+		// An account that doesn't exist has a zero balance and nonce.
+		return database.Account{AccountID: accountID}, nil
+	case err != nil:
+		return database.Account{}, &rpcError{Code: rpcServerError, Message: err.Error()}
+	}
+
+	return account, nil
+}
+
+// rpcBlockNumber converts a block tag or hex encoded number into a block
+// number.
+func (h *Handler) rpcBlockNumber(tag string) (uint64, error) {
+	switch tag {
+	case "latest", "pending", "safe", "finalized":
+		return h.State.LatestBlock().Header.Number, nil
+	case "earliest":
+		return 0, nil
+	}
+
+	num, err := hexutil.DecodeUint64(tag)
+	if err != nil {
+		return 0, &rpcError{Code: rpcInvalidParams, Message: fmt.Sprintf("invalid block number %q: %s", tag, err)}
+	}
+
+	return num, nil
+}
+
+// rpcBlock converts a block into the object returned by the eth namespace.
+// With full set, the transactions are returned as objects instead of hashes.
+func (h *Handler) rpcBlock(block database.Block, full bool) map[string]any {
+	hash := block.Hash()
+
+	trans := block.MerkleTree.Values()
+	txs := make([]any, len(trans))
+	for i, tran := range trans {
+		if !full {
+			txs[i] = tran.TxHash()
+			continue
+		}
+		txs[i] = map[string]any{
+			"hash":             tran.TxHash(),
+			"blockHash":        hash,
+			"blockNumber":      hexutil.EncodeUint64(block.Header.Number),
+			"transactionIndex": hexutil.EncodeUint64(uint64(i)),
+			"chainId":          hexutil.EncodeUint64(uint64(tran.ChainID)),
+			"from":             tran.FromID,
+			"to":               tran.ToID,
+			"nonce":            hexutil.EncodeUint64(tran.Nonce),
+			"value":            hexutil.EncodeUint64(tran.Value),
+			"tip":              hexutil.EncodeUint64(tran.Tip),
+			"gas":              hexutil.EncodeUint64(tran.GasUnits),
+			"gasPrice":         hexutil.EncodeUint64(tran.GasPrice),
+			"input":            hexutil.Encode(tran.Data),
+			"v":                (*hexutil.Big)(tran.V),
+			"r":                (*hexutil.Big)(tran.R),
+			"s":                (*hexutil.Big)(tran.S),
+		}
+	}
+
+	return map[string]any{
+		"number":           hexutil.EncodeUint64(block.Header.Number),
+		"hash":             hash,
+		"parentHash":       block.Header.PrevBlockHash,
+		"timestamp":        hexutil.EncodeUint64(block.Header.TimeStamp),
+		"miner":            block.Header.BeneficiaryID,
+		"difficulty":       hexutil.EncodeUint64(uint64(block.Header.Difficulty)),
+		"miningReward":     hexutil.EncodeUint64(block.Header.MiningReward),
+		"stateRoot":        block.Header.StateRoot,
+		"transactionsRoot": block.Header.TransRoot,
+		"nonce":            hexutil.EncodeUint64(block.Header.Nonce),
+		"transactions":     txs,
+	}
+}
+
+// rpcParams decodes the positional parameters into the specified values. At
+// least the required number of parameters must be present.
+func rpcParams(params []json.RawMessage, required int, values ...any) error {
+	if len(params) < required {
+		return &rpcError{Code: rpcInvalidParams, Message: fmt.Sprintf("expected at least %d params, got %d", required, len(params))}
+	}
+
+	for i, value := range values {
+		if i >= len(params) {
+			break
+		}
+		if err := json.Unmarshal(params[i], value); err != nil {
+			return &rpcError{Code: rpcInvalidParams, Message: fmt.Sprintf("param %d: %s", i, err)}
+		}
+	}
+
+	return nil
+}
+
+// rpcSignedTx decodes a signed transaction provided as a JSON object or as
+// a hex encoded string of that JSON.
+func rpcSignedTx(param json.RawMessage) (database.SignedTx, error) {
+	data := []byte(param)
+
+	var str string
+	if err := json.Unmarshal(param, &str); err == nil {
+		if data, err = hexutil.Decode(strings.TrimSpace(str)); err != nil {
+			return database.SignedTx{}, err
+		}
+	}
+
+	var signedTx database.SignedTx
+	if err := json.Unmarshal(data, &signedTx); err != nil {
+		return database.SignedTx{}, err
+	}
+
+	return signedTx, nil
+}
+
+// rpcFailure constructs a response holding an error.
+func rpcFailure(id json.RawMessage, code int, message string) rpcResponse {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+
+	return rpcResponse{
+		JSONRPC: "2.0",
+		Error:   &rpcError{Code: code, Message: message},
+		ID:      id,
+	}
+}
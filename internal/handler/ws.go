@@ -0,0 +1,161 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/opplieam/bund-blockchain/internal/blockchain/database"
+	"github.com/opplieam/bund-blockchain/internal/blockchain/state"
+	"golang.org/x/net/websocket"
+)
+
+// CORE NOTE: A WebSocket client picks the topics it wants with the topics
+// query parameter, like /ws?topics=newBlocks,accountChanged:0xF01813E4B8...
+// Messages are only ever sent by the node. A client that falls behind is
+// dropped by the feed and the connection is closed, so it has to reconnect
+// and catch up using the query endpoints. Browsers let any page open a
+// WebSocket to any host, so connections from pages on other origins are
+// refused unless the origin is configured.
+
+// Settings for a WebSocket subscription.
+const (
+	wsBuffer       = 64
+	wsWriteTimeout = 10 * time.Second
+)
+
+// Subscribe upgrades the connection to a WebSocket and streams the events
+// for the requested topics.
+func (h *Handler) Subscribe(c echo.Context) error {
+	var topics []string
+	for _, topic := range strings.Split(c.QueryParam("topics"), ",") {
+		topic = strings.TrimSpace(topic)
+		if topic == "" {
+			continue
+		}
+		if !state.ValidTopic(topic) {
+			return c.String(http.StatusBadRequest, "invalid topic "+topic)
+		}
+		topics = append(topics, topic)
+	}
+
+	if len(topics) == 0 {
+		return c.String(http.StatusBadRequest, "no topics provided")
+	}
+
+	// The websocket.Server is used instead of websocket.Handler so the
+	// origin check can allow clients that don't send an origin.
+	server := websocket.Server{
+		Handshake: h.checkOrigin,
+		Handler: func(ws *websocket.Conn) {
+			h.stream(ws, topics)
+		},
+	}
+	server.ServeHTTP(c.Response(), c.Request())
+
+	return nil
+}
+
+// =============================================================================
+
+// checkOrigin allows clients that don't send an origin, like command line
+// tools, pages served from the node's own host and the configured origins.
+func (h *Handler) checkOrigin(config *websocket.Config, req *http.Request) error {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		return fmt.Errorf("invalid origin %q: %w", origin, err)
+	}
+	if u.Host == req.Host {
+		return nil
+	}
+
+	for _, allowed := range h.WSOrigins {
+		allowed = strings.TrimSpace(allowed)
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("origin %q not allowed", origin)
+}
+
+// stream writes the feed events to the connection until the client goes
+// away or the subscriber is dropped.
+func (h *Handler) stream(ws *websocket.Conn, topics []string) {
+	defer ws.Close()
+
+	sub := h.State.Subscribe(topics, wsBuffer)
+	defer sub.Unsubscribe()
+
+	// The client isn't expected to send anything, reading is only used to
+	// know when the client closes the connection.
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		var msg []byte
+		for {
+			if err := websocket.Message.Receive(ws, &msg); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case event, ok := <-sub.C:
+			if !ok {
+				return
+			}
+
+			if err := ws.SetWriteDeadline(time.Now().Add(wsWriteTimeout)); err != nil {
+				return
+			}
+			if err := websocket.JSON.Send(ws, h.toFeedMsg(event)); err != nil {
+				h.Log.Info("websocket", "status", "send failed", "error", err)
+				return
+			}
+
+		case <-sub.Dropped():
+			h.Log.Info("websocket", "status", "subscriber dropped, too slow")
+			return
+
+		case <-closed:
+			return
+		}
+	}
+}
+
+// toFeedMsg converts a feed event into the message sent to the client.
+func (h *Handler) toFeedMsg(event state.FeedEvent) feedMsg {
+	msg := feedMsg{
+		Topic: event.Topic,
+	}
+
+	switch {
+	case event.Topic == state.TopicNewBlocks:
+		blockData := database.NewBlockData(event.Block)
+		msg.Block = &blockData
+
+	case event.Topic == state.TopicPendingTransactions:
+		tran := h.toTx(event.Tx)
+		msg.Tx = &tran
+
+	case strings.HasPrefix(event.Topic, state.TopicAccountChanged):
+		msg.Account = &act{
+			Account: event.Account.AccountID,
+			Name:    h.NS.Lookup(event.Account.AccountID),
+			Balance: event.Account.Balance,
+			Nonce:   event.Account.Nonce,
+		}
+	}
+
+	return msg
+}
@@ -0,0 +1,283 @@
+// Package metrics collects measurements of the node internals from the event
+// bus and serves them in the Prometheus text format.
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/opplieam/bund-blockchain/internal/blockchain/database"
+	"github.com/opplieam/bund-blockchain/internal/blockchain/events"
+	"github.com/opplieam/bund-blockchain/internal/blockchain/state"
+)
+
+// CORE NOTE: Counters and histograms are built from the events published by
+// the state, database and worker, so nothing in those packages knows about
+// metrics. Values that describe the node right now, like the chain height,
+// are read from the state when the metrics are scraped. The exposition format
+// is simple enough that no client library is needed.
+
+// Set of histogram buckets in seconds.
+var (
+	latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+	solveBuckets   = []float64{0.1, 0.5, 1, 5, 10, 30, 60, 120, 300, 600}
+)
+
+// Metrics maintains the measurements for the node.
+type Metrics struct {
+	state *state.State
+
+	mu                sync.Mutex
+	blocksMined       uint64
+	blocksAccepted    uint64
+	blocksRejected    map[string]uint64
+	reorgs            uint64
+	powAttempts       uint64
+	powHashRate       float64
+	powSolve          *histogram
+	peerRequests      map[string]*histogram
+	peerRequestErrors map[string]uint64
+	storageWrite      *histogram
+}
+
+// New constructs the metrics for the node and starts collecting the events
+// published by the state.
+func New(st *state.State) *Metrics {
+	m := Metrics{
+		state:             st,
+		blocksRejected:    make(map[string]uint64),
+		powSolve:          newHistogram(solveBuckets),
+		peerRequests:      make(map[string]*histogram),
+		peerRequestErrors: make(map[string]uint64),
+		storageWrite:      newHistogram(latencyBuckets),
+	}
+
+	st.Events().Subscribe(m.handle)
+
+	return &m
+}
+
+// ServeHTTP implements the http.Handler interface to serve the metrics.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.Write(w)
+}
+
+// Write writes all the metrics to the writer in the Prometheus text format.
+func (m *Metrics) Write(w io.Writer) error {
+	var b strings.Builder
+
+	// Values read from the state.
+	writeMetric(&b, "bund_chain_height", "gauge", "Number of the latest block in the chain.", "", m.state.LatestBlock().Header.Number)
+	writeMetric(&b, "bund_mempool_size", "gauge", "Number of transactions in the mempool.", "", m.state.MempoolLength())
+	writeMetric(&b, "bund_mempool_pending", "gauge", "Number of transactions in the mempool that can be mined next.", "", m.state.MempoolPendingLength())
+	writeMetric(&b, "bund_peers", "gauge", "Number of known peers, not including this node.", "", len(m.state.KnownExternalPeers()))
+
+	m.mu.Lock()
+	writeMetric(&b, "bund_blocks_mined_total", "counter", "Blocks mined by this node.", "", m.blocksMined)
+	writeMetric(&b, "bund_blocks_accepted_total", "counter", "Blocks added to the chain.", "", m.blocksAccepted)
+
+	writeHeader(&b, "bund_blocks_rejected_total", "counter", "Proposed blocks that failed validation by reason.")
+	for _, reason := range sortedKeys(m.blocksRejected) {
+		writeValue(&b, "bund_blocks_rejected_total", labels("reason", reason), m.blocksRejected[reason])
+	}
+
+	writeMetric(&b, "bund_reorgs_total", "counter", "Times the chain switched to another branch.", "", m.reorgs)
+	writeMetric(&b, "bund_pow_hash_attempts_total", "counter", "Hashes tried searching for POW solutions.", "", m.powAttempts)
+	writeMetric(&b, "bund_pow_hash_rate", "gauge", "Hashes per second across all workers in the last POW search.", "", formatFloat(m.powHashRate))
+
+	writeHeader(&b, "bund_pow_solve_duration_seconds", "histogram", "Time taken to find a POW solution.")
+	m.powSolve.write(&b, "bund_pow_solve_duration_seconds", "")
+
+	writeHeader(&b, "bund_peer_request_duration_seconds", "histogram", "Latency of requests sent to peers by request.")
+	for _, request := range sortedKeys(m.peerRequests) {
+		m.peerRequests[request].write(&b, "bund_peer_request_duration_seconds", labels("request", request))
+	}
+
+	writeHeader(&b, "bund_peer_request_errors_total", "counter", "Requests sent to peers that failed by request.")
+	for _, request := range sortedKeys(m.peerRequestErrors) {
+		writeValue(&b, "bund_peer_request_errors_total", labels("request", request), m.peerRequestErrors[request])
+	}
+
+	writeHeader(&b, "bund_storage_write_duration_seconds", "histogram", "Time taken to write a block to storage.")
+	m.storageWrite.write(&b, "bund_storage_write_duration_seconds", "")
+	m.mu.Unlock()
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// =============================================================================
+
+// handle updates the metrics based on the event.
+func (m *Metrics) handle(ev events.Event) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch ev.Kind {
+	case events.KindBlockMined:
+		m.blocksMined++
+
+	case events.KindBlockAdded:
+		m.blocksAccepted++
+
+	case events.KindBlockRejected:
+		m.blocksRejected[rejectReason(ev.Err)]++
+
+	case events.KindReorg:
+		m.reorgs++
+
+	case events.KindPowCompleted:
+		if attempts, ok := ev.Data.(uint64); ok {
+			m.powAttempts += attempts
+			if ev.Duration > 0 {
+				m.powHashRate = float64(attempts) / ev.Duration.Seconds()
+			}
+		}
+		if ev.Err == nil {
+			m.powSolve.observe(ev.Duration.Seconds())
+		}
+
+	case events.KindPeerRequest:
+		h, exists := m.peerRequests[ev.Op]
+		if !exists {
+			h = newHistogram(latencyBuckets)
+			m.peerRequests[ev.Op] = h
+		}
+		h.observe(ev.Duration.Seconds())
+
+		if ev.Err != nil {
+			m.peerRequestErrors[ev.Op]++
+		}
+
+	case events.KindStorageWrite:
+		m.storageWrite.observe(ev.Duration.Seconds())
+	}
+}
+
+// rejectReason identifies the validation check that rejected a block.
+func rejectReason(err error) string {
+	switch {
+	case errors.Is(err, database.ErrChainForked):
+		return "chain_forked"
+	case errors.Is(err, database.ErrInvalidDifficulty):
+		return "difficulty"
+	case errors.Is(err, database.ErrInvalidHash):
+		return "hash"
+	case errors.Is(err, database.ErrInvalidNumber):
+		return "number"
+	case errors.Is(err, database.ErrInvalidTimestamp):
+		return "timestamp"
+	case errors.Is(err, database.ErrInvalidMerkleRoot):
+		return "merkle_root"
+	case errors.Is(err, database.ErrInvalidStateRoot):
+		return "state_root"
+	case errors.Is(err, database.ErrTxExpired):
+		return "tx_expired"
+	case errors.Is(err, database.ErrInvalidSigner):
+		return "signer"
+	case errors.Is(err, database.ErrInvalidMiningReward):
+		return "mining_reward"
+	}
+
+	return "other"
+}
+
+// =============================================================================
+
+// histogram counts observations into cumulative buckets.
+type histogram struct {
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+// newHistogram constructs a histogram with the specified bucket bounds.
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)),
+	}
+}
+
+// observe records the value.
+func (h *histogram) observe(v float64) {
+	for i, bound := range h.buckets {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// write writes the histogram series with the specified labels.
+func (h *histogram) write(b *strings.Builder, name string, lbls string) {
+	for i, bound := range h.buckets {
+		writeValue(b, name+"_bucket", joinLabels(lbls, labels("le", formatFloat(bound))), h.counts[i])
+	}
+	writeValue(b, name+"_bucket", joinLabels(lbls, labels("le", "+Inf")), h.count)
+	writeValue(b, name+"_sum", lbls, formatFloat(h.sum))
+	writeValue(b, name+"_count", lbls, h.count)
+}
+
+// =============================================================================
+
+// writeMetric writes a metric with a single value.
+func writeMetric(b *strings.Builder, name string, typ string, help string, lbls string, value any) {
+	writeHeader(b, name, typ, help)
+	writeValue(b, name, lbls, value)
+}
+
+// writeHeader writes the help and type lines for a metric.
+func writeHeader(b *strings.Builder, name string, typ string, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// writeValue writes a single sample.
+func writeValue(b *strings.Builder, name string, lbls string, value any) {
+	if lbls != "" {
+		lbls = "{" + lbls + "}"
+	}
+	fmt.Fprintf(b, "%s%s %v\n", name, lbls, value)
+}
+
+// labels formats a label pair.
+func labels(name string, value string) string {
+	return name + "=" + strconv.Quote(value)
+}
+
+// joinLabels combines formatted label pairs.
+func joinLabels(lbls ...string) string {
+	var out []string
+	for _, l := range lbls {
+		if l != "" {
+			out = append(out, l)
+		}
+	}
+
+	return strings.Join(out, ",")
+}
+
+// formatFloat formats a float the way Prometheus expects.
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// sortedKeys returns the keys of the map in order so the output is stable.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}